@@ -1,14 +1,17 @@
 package cli
 
 import (
-	"bytes"
+	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"log"
-	"net"
 	"os"
 	"os/signal"
 	"os/user"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/rjeczalik/fakerpc"
 
@@ -27,6 +30,29 @@ var defaultOut = func(args ...interface{}) {
 	}
 }
 
+// A sink is a record-log the record command writes to.
+type sink interface {
+	fakerpc.LogSink
+	Close() error
+}
+
+// rotate reports whether any of the rotation flags was set.
+func rotate(ctx *cli.Context) bool {
+	return ctx.Int("rotate-size") > 0 || ctx.Int("rotate-conns") > 0 || ctx.Duration("rotate-interval") > 0
+}
+
+// syncInterval is how often the record command syncs the log to disk.
+const syncInterval = 5 * time.Second
+
+// shutdownTimeout is how long exchanges in progress are waited for after
+// a signal is caught.
+const shutdownTimeout = 10 * time.Second
+
+// shutdownContext gives a context for shutting down Servers and Proxies.
+func shutdownContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), shutdownTimeout)
+}
+
 func logfile() (path string) {
 	u, err := user.Current()
 	if err != nil {
@@ -61,21 +87,131 @@ func NewCLI() *CLI {
 	cl.app.Version = "0.1.0"
 	cl.app.Usage = "use gentle and with great care"
 	cl.app.Flags = []cli.Flag{
-		cli.StringFlag{Name: "addr", Value: "localhost:0", Usage: "An address to listen on"},
+		cli.StringFlag{Name: "addr", Value: "localhost:0", Usage: "An address to listen on, or unix://<path> of a Unix domain socket"},
 		cli.StringFlag{Name: "log", Value: logfile(), Usage: "A path to the record-log file (or ngrep output)"},
 	}
 	cl.app.Commands = []cli.Command{{
 		Name:   "record",
 		Usage:  "Proxies connections recording them all to the record-log",
 		Action: cl.Record,
+		Flags: []cli.Flag{
+			cli.IntFlag{Name: "rotate-size", Usage: "Rotates the record-log after it grows over given MiB"},
+			cli.IntFlag{Name: "rotate-conns", Usage: "Rotates the record-log after given number of connections"},
+			cli.DurationFlag{Name: "rotate-interval", Usage: "Rotates the record-log after given time"},
+			cli.IntFlag{Name: "max-total", Usage: "Removes the oldest record-logs above given total MiB"},
+			cli.IntFlag{Name: "max-files", Usage: "Removes the oldest record-logs above given number of files"},
+			cli.IntFlag{Name: "dedup", Usage: "Keeps bodies of at least given bytes once, in a bodies dir next to the record-log"},
+			cli.BoolFlag{Name: "encrypt", Usage: "Encrypts the record-log with a key from FAKERPC_KEY or FAKERPC_KEY_FILE"},
+		},
 	}, {
 		Name:   "reply",
-		Usage:  "Serves connections with recorded responses from the record-log",
+		Usage:  "Serves connections with recorded responses from the record-log, or from many given as addr=log",
 		Action: cl.Reply,
 	}, {
 		Name:   "show",
-		Usage:  "Shows record-log as a ngrep output",
+		Usage:  "Shows calls of the record-log per connection, with decoded and pretty-printed bodies",
 		Action: cl.Show,
+		Flags: []cli.Flag{
+			cli.IntFlag{Name: "conn", Value: -1, Usage: "Shows calls of the connection with given index only"},
+			cli.StringFlag{Name: "path", Usage: "Shows calls, which URL path matches given pattern"},
+			cli.StringFlag{Name: "method", Usage: "Shows calls with given comma-separated methods"},
+			cli.IntFlag{Name: "status", Usage: "Shows calls with given response status code"},
+			cli.BoolFlag{Name: "json", Usage: "Prints the calls as a JSON array"},
+			cli.BoolFlag{Name: "ngrep", Usage: "Prints the whole record-log as a ngrep output"},
+		},
+	}, {
+		Name:   "lint",
+		Usage:  "Reports problems found in the record-logs, either files or testdata dirs",
+		Action: cl.Lint,
+	}, {
+		Name:   "upgrade",
+		Usage:  "Rewrites record-logs in place in the newest format, either files or testdata dirs",
+		Action: cl.Upgrade,
+	}, {
+		Name:   "diff",
+		Usage:  "Compares calls recorded in two record-logs, exiting with 1 if they differ",
+		Action: cl.Diff,
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "ignore", Value: "Date", Usage: "A comma-separated list of response headers not to compare"},
+			cli.StringFlag{Name: "ignore-field", Usage: "A comma-separated list of JSON keys not to compare, like ids or dates"},
+			cli.StringFlag{Name: "match", Value: "grpc", Usage: "A matcher pairing the calls: request, xml or grpc"},
+		},
+	}, {
+		Name:   "verify",
+		Usage:  "Replays the record-log against a live service, exiting with 1 if responses differ",
+		Action: cl.Verify,
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "ignore", Value: "Date", Usage: "A comma-separated list of response headers not to compare"},
+			cli.StringFlag{Name: "ignore-field", Usage: "A comma-separated list of JSON keys not to compare, like ids or dates"},
+		},
+	}, {
+		Name:   "convert",
+		Usage:  "Converts a record-log between formats: gob, ngrep or json; - stands for stdin or stdout",
+		Action: cl.Convert,
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "from", Usage: "A format of the input, detected by the extension or content if empty"},
+			cli.StringFlag{Name: "to", Usage: "A format of the output, detected by the extension if empty"},
+		},
+	}, {
+		Name:   "stats",
+		Usage:  "Summarizes calls, statuses, sizes and latencies of the record-logs, either files or dirs",
+		Action: cl.Stats,
+		Flags: []cli.Flag{
+			cli.IntFlag{Name: "top", Value: 20, Usage: "Shows given number of the most called endpoints, 0 shows all"},
+			cli.BoolFlag{Name: "json", Usage: "Prints the summary as a JSON object"},
+		},
+	}, {
+		Name:   "rm",
+		Usage:  "Removes a call, a connection or the matching calls from the record-log in place",
+		Action: cl.Rm,
+		Flags: []cli.Flag{
+			cli.IntFlag{Name: "conn", Value: -1, Usage: "An index of the connection, as printed by show"},
+			cli.IntFlag{Name: "call", Value: -1, Usage: "An index of the call within the connection"},
+			cli.StringFlag{Name: "path", Usage: "Removes calls, which URL path matches given pattern"},
+			cli.StringFlag{Name: "method", Usage: "Removes calls with given comma-separated methods"},
+		},
+	}, {
+		Name:   "edit",
+		Usage:  "Replaces a body or headers of a recorded response or request in place",
+		Action: cl.Edit,
+		Flags: []cli.Flag{
+			cli.IntFlag{Name: "conn", Value: -1, Usage: "An index of the connection, as printed by show"},
+			cli.IntFlag{Name: "call", Value: -1, Usage: "An index of the call within the connection, 0 if not set"},
+			cli.BoolFlag{Name: "request", Usage: "Edits the request instead of the response"},
+			cli.StringFlag{Name: "body", Usage: "A file the new body is read from, - reads the stdin"},
+			cli.StringSliceFlag{Name: "set", Value: &cli.StringSlice{}, Usage: "Sets a header given as \"Key: value\""},
+			cli.StringSliceFlag{Name: "unset", Value: &cli.StringSlice{}, Usage: "Removes a header"},
+		},
+	}, {
+		Name:   "export",
+		Usage:  "Prints recorded requests as curl command lines or Go snippets, with sensitive headers redacted",
+		Action: cl.Export,
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "format", Value: "curl", Usage: "A format of the requests: curl or go"},
+			cli.StringFlag{Name: "target", Usage: "A base URL the requests are sent to, the recorded host if empty"},
+			cli.StringFlag{Name: "redact", Value: strings.Join(fakerpc.Redacted, ","), Usage: "A comma-separated list of headers, which values are redacted"},
+			cli.IntFlag{Name: "conn", Value: -1, Usage: "An index of the connection, as printed by show"},
+			cli.IntFlag{Name: "call", Value: -1, Usage: "An index of the call within the connection"},
+			cli.StringFlag{Name: "path", Usage: "Exports calls, which URL path matches given pattern"},
+			cli.StringFlag{Name: "method", Usage: "Exports calls with given comma-separated methods"},
+		},
+	}, {
+		Name:   "serve",
+		Usage:  "Runs record and reply endpoints described by a JSON config file",
+		Action: cl.Serve,
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "config", Usage: "A path to the config file"},
+		},
+	}, {
+		Name:   "gen-test",
+		Usage:  "Generates a Go test replying to the record-log with fakerpc.Fixture",
+		Action: cl.GenTest,
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "name", Usage: "A name of the test function, like TestUser"},
+			cli.StringFlag{Name: "pkg", Usage: "A package of the test, the base name of the dir if empty"},
+			cli.StringFlag{Name: "dir", Value: ".", Usage: "A dir of the package the test is generated in"},
+			cli.BoolFlag{Name: "force", Usage: "Overwrites the test and the log if they already exist"},
+		},
 	}}
 	return cl
 }
@@ -95,6 +231,61 @@ func (cl *CLI) Record(ctx *cli.Context) {
 	p.Record = func(t *fakerpc.Transmission) {
 		cl.Out(fmt.Sprintf("fakerpc: T %s -> %s (%d)", t.Src, t.Dst, len(t.Raw)))
 	}
+	logFile := ctx.GlobalString("log")
+	var key string
+	if ctx.Bool("encrypt") {
+		if key, err = fakerpc.EnvKey(); err != nil {
+			cl.Err(err)
+			cl.Exit(1)
+		}
+		if key == "" {
+			cl.Err("fakerpc: --encrypt requires FAKERPC_KEY or FAKERPC_KEY_FILE to be set")
+			cl.Exit(1)
+		}
+		if ctx.Int("dedup") > 0 {
+			cl.Err("fakerpc: --encrypt can't be used with --dedup, as the bodies dir is not encrypted")
+			cl.Exit(1)
+		}
+	}
+	// Connections are written to the log as soon as they're closed, so the log
+	// is not lost when the recording gets interrupted.
+	var snk sink
+	if rotate(ctx) {
+		lr := fakerpc.NewLogRotator(logFile)
+		lr.MaxSize = int64(ctx.Int("rotate-size")) << 20
+		lr.MaxConns = ctx.Int("rotate-conns")
+		lr.Interval = ctx.Duration("rotate-interval")
+		lr.MaxTotal = int64(ctx.Int("max-total")) << 20
+		lr.MaxFiles = ctx.Int("max-files")
+		lr.SyncInterval, lr.Key = syncInterval, key
+		if n := ctx.Int("dedup"); n > 0 {
+			lr.Store, lr.Threshold = fakerpc.StoreFor(logFile), n
+		}
+		lr.Rotate = func(file string) {
+			cl.Out(fmt.Sprintf("fakerpc: Recording to the %q file . . .", file))
+		}
+		snk = lr
+	} else {
+		f, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			cl.Err(err)
+			cl.Exit(1)
+		}
+		var w io.Writer = f
+		if key != "" {
+			if w, err = fakerpc.Encrypt(f, key); err != nil {
+				cl.Err(err)
+				cl.Exit(1)
+			}
+		}
+		lw := fakerpc.NewLogWriter(w)
+		lw.SyncInterval = syncInterval
+		if n := ctx.Int("dedup"); n > 0 {
+			lw.Store, lw.Threshold = fakerpc.StoreFor(logFile), n
+		}
+		snk = lw
+	}
+	p.Sink = snk
 	done, sig := make(chan struct{}), make(chan os.Signal, 1)
 	go func() {
 		if err := p.ListenAndServe(); err != nil {
@@ -104,67 +295,340 @@ func (cl *CLI) Record(ctx *cli.Context) {
 		close(done)
 	}()
 	signal.Notify(sig, os.Interrupt, os.Kill)
-	cl.Out(fmt.Sprintf("fakerpc: Proxy recording on %s . . .", p.Addr()))
+	cl.Out(fmt.Sprintf("fakerpc: Proxy recording on %s to the %q file . . .", p.Addr(), logFile))
 	<-sig
 	cl.Out("fakerpc: Signal caught; stopping proxy . . .")
-	log, err := p.Stop()
+	sctx, cancel := shutdownContext()
+	_, err = p.Shutdown(sctx)
+	cancel()
 	if err != nil {
 		cl.Err(err)
 		cl.Exit(1)
 	}
 	<-done
-	logFile := ctx.GlobalString("log")
-	cl.Out(fmt.Sprintf("fakerpc: Saving log to the %q file . . .", logFile))
-	if err = fakerpc.WriteLog(logFile, log); err != nil {
+	if err = snk.Close(); err != nil {
 		cl.Err(err)
 		cl.Exit(1)
 	}
+	if lr, ok := snk.(*fakerpc.LogRotator); ok {
+		cl.Out(fmt.Sprintf("fakerpc: Log saved to the %q files", lr.Files()))
+		return
+	}
+	cl.Out(fmt.Sprintf("fakerpc: Log saved to the %q file", logFile))
 }
 
-// Server TODO(rjeczalik): document
+// Reply serves connections with recorded responses. With no arguments it
+// replies with the log set by the --log flag on the address set by the --addr
+// flag; otherwise every argument is an addr=log pair and all the Servers are
+// run in a single process, with messages prefixed by their addresses. It
+// returns after all the Servers replied with their logs or a signal is caught.
 func (cl *CLI) Reply(ctx *cli.Context) {
-	l, err := fakerpc.ReadLog(ctx.GlobalString("log"))
-	if err != nil {
+	if len(ctx.Args()) == 0 {
+		svc, err := cl.replyService("", &fakerpc.EndpointConfig{
+			Mode: "reply",
+			Addr: ctx.GlobalString("addr"),
+			Log:  ctx.GlobalString("log"),
+		})
+		if err != nil {
+			cl.Err(err)
+			cl.Exit(1)
+		}
+		cl.run([]*service{svc})
+		return
+	}
+	cfg := &fakerpc.Config{}
+	for _, arg := range ctx.Args() {
+		i := strings.IndexByte(arg, '=')
+		if i == -1 {
+			cl.Err(fmt.Sprintf("fakerpc: invalid argument %q; want addr=log", arg))
+			cl.Exit(1)
+		}
+		cfg.Endpoints = append(cfg.Endpoints, fakerpc.EndpointConfig{Mode: "reply", Addr: arg[:i], Log: arg[i+1:]})
+	}
+	if err := cfg.Validate(); err != nil {
 		cl.Err(err)
 		cl.Exit(1)
 	}
-	srv, err := fakerpc.NewServer(ctx.GlobalString("addr"), l)
+	svcs := make([]*service, 0, len(cfg.Endpoints))
+	for i := range cfg.Endpoints {
+		e := &cfg.Endpoints[i]
+		svc, err := cl.replyService(e.String(), e)
+		if err != nil {
+			cl.Err(fmt.Sprintf("%s %v", prefix(e.String()), err))
+			cl.Exit(1)
+		}
+		svcs = append(svcs, svc)
+	}
+	cl.run(svcs)
+}
+
+// Lint validates record-logs given as arguments, or the one set by the --log
+// flag when there are none. Directories are searched for *.gzob files. It exits
+// with non-zero code if any issue was found.
+func (cl *CLI) Lint(ctx *cli.Context) {
+	files, err := logfiles(ctx.Args(), ctx.GlobalString("log"))
 	if err != nil {
 		cl.Err(err)
 		cl.Exit(1)
 	}
-	srv.Reply = func(src, dst *net.TCPAddr, n int64, err error) {
+	var n int
+	for _, file := range files {
+		l, lf, err := fakerpc.ReadLogFile(file)
 		if err != nil {
-			cl.Err(fmt.Sprintf("fakerpc: T %s -> %s (%d) error: %v", src, dst, n, err))
-		} else {
-			cl.Out(fmt.Sprintf("fakerpc: T %s -> %s (%d)", src, dst, n))
+			cl.Err(fmt.Sprintf("%s: %v", file, err))
+			n++
+			continue
+		}
+		if lf.Truncated {
+			cl.Err(fmt.Sprintf("%s: log is truncated; its unreadable tail is ignored", file))
+			n++
+		}
+		for _, issue := range fakerpc.Validate(l) {
+			cl.Err(fmt.Sprintf("%s: %v", file, issue))
+			n++
 		}
 	}
-	done := make(chan struct{})
-	go func() {
-		if err := srv.ListenAndServe(); err != nil {
-			cl.Err(err)
+	if n != 0 {
+		cl.Err(fmt.Sprintf("fakerpc: found %d issue(s) in %d file(s)", n, len(files)))
+		cl.Exit(1)
+	}
+}
+
+// Upgrade rewrites record-logs given as arguments, or the one set by the --log
+// flag when there are none, in the newest format. Logs already in the newest
+// format are left untouched; encrypted logs stay encrypted. Truncated logs are
+// refused, as rewriting them would drop their unreadable tails for good.
+func (cl *CLI) Upgrade(ctx *cli.Context) {
+	files, err := logfiles(ctx.Args(), ctx.GlobalString("log"))
+	if err != nil {
+		cl.Err(err)
+		cl.Exit(1)
+	}
+	for _, file := range files {
+		l, lf, err := fakerpc.ReadLogFile(file)
+		if err == nil && lf.Codec.Name != "gob" {
+			err = fmt.Errorf("fakerpc: not a gob log, but a %s output", lf.Codec.Name)
+		}
+		if err == nil && lf.Truncated {
+			err = fmt.Errorf("fakerpc: log is truncated; upgrading it would drop its unreadable tail")
+		}
+		if err != nil {
+			cl.Err(fmt.Sprintf("%s: %v", file, err))
 			cl.Exit(1)
 		}
-		close(done)
-	}()
-	cl.Out(fmt.Sprintf("fakerpc: Server replying on %s . . .", srv.Addr()))
-	<-done
+		version := lf.Version
+		if version == fakerpc.LogVersion {
+			cl.Out(fmt.Sprintf("fakerpc: %s is up to date", file))
+			continue
+		}
+		if err = lf.WriteLog(file, l); err != nil {
+			cl.Err(fmt.Sprintf("%s: %v", file, err))
+			cl.Exit(1)
+		}
+		cl.Out(fmt.Sprintf("fakerpc: %s upgraded from version %d to %d", file, version, fakerpc.LogVersion))
+	}
+}
+
+// Diff compares two record-logs given as arguments, printing added, removed and
+// modified calls. Like diff(1), it exits with 0 if the logs do not differ, 1 if
+// they do and 2 on error.
+func (cl *CLI) Diff(ctx *cli.Context) {
+	if len(ctx.Args()) != 2 {
+		cl.Err("fakerpc: missing (...) diff <old record-log> <new record-log>")
+		cl.Exit(2)
+	}
+	match, ok := fakerpc.Matchers[ctx.String("match")]
+	if !ok {
+		cl.Err(fmt.Sprintf("fakerpc: unknown matcher %q", ctx.String("match")))
+		cl.Exit(2)
+	}
+	var logs [2]*fakerpc.Log
+	for i, file := range ctx.Args() {
+		l, err := fakerpc.ReadLog(file)
+		if err != nil {
+			cl.Err(fmt.Sprintf("%s: %v", file, err))
+			cl.Exit(2)
+		}
+		logs[i] = l
+	}
+	d := differ(ctx)
+	d.Match = match
+	changes, err := d.Diff(logs[0], logs[1])
+	if err != nil {
+		cl.Err(err)
+		cl.Exit(2)
+	}
+	for _, c := range changes {
+		cl.Out(c)
+	}
+	if len(changes) != 0 {
+		cl.Exit(1)
+	}
 }
 
-// Show TODO(rjeczalik): document
-func (cl *CLI) Show(ctx *cli.Context) {
+// Verify replays requests of the record-log against a live service given as
+// an argument, printing calls, which responses differ from the recorded ones.
+// It exits with 0 if none differ, 1 if any does and 2 on error.
+func (cl *CLI) Verify(ctx *cli.Context) {
+	target := ctx.Args().First()
+	if target == "" {
+		cl.Err("fakerpc: missing (...) verify <target url>")
+		cl.Exit(2)
+	}
 	l, err := fakerpc.ReadLog(ctx.GlobalString("log"))
 	if err != nil {
 		cl.Err(err)
+		cl.Exit(2)
+	}
+	v := &fakerpc.Verifier{Differ: *differ(ctx)}
+	changes, err := v.Verify(l, target)
+	if err != nil {
+		cl.Err(err)
+		cl.Exit(2)
+	}
+	for _, c := range changes {
+		cl.Out(c)
+	}
+	if len(changes) != 0 {
+		cl.Err(fmt.Sprintf("fakerpc: %d call(s) differ from the record-log", len(changes)))
+		cl.Exit(1)
+	}
+}
+
+// Convert reads a record-log from a file given as the first argument and writes
+// it to a file given as the second one, in formats set by the --from and --to
+// flags. A missing or "-" argument stands for the stdin or the stdout.
+func (cl *CLI) Convert(ctx *cli.Context) {
+	in, out := ctx.Args().Get(0), ctx.Args().Get(1)
+	l, err := convertRead(in, ctx.String("from"))
+	if err != nil {
+		cl.Err(err)
+		cl.Exit(1)
+	}
+	var c fakerpc.Codec
+	switch to := ctx.String("to"); {
+	case to != "":
+		if c, err = codec(to); err != nil {
+			cl.Err(err)
+			cl.Exit(1)
+		}
+	case out != "" && out != "-":
+		var ok bool
+		if c, ok = fakerpc.CodecByExt(out); !ok {
+			cl.Err(fmt.Sprintf("fakerpc: unable to detect format of %s; use the --to flag", out))
+			cl.Exit(1)
+		}
+	default:
+		cl.Err("fakerpc: the --to flag is required when writing to the stdout")
 		cl.Exit(1)
 	}
-	var buf bytes.Buffer
-	if err = fakerpc.NgrepMarshal(&buf, l); err != nil {
+	if out == "" || out == "-" {
+		err = c.Marshal(os.Stdout, l)
+	} else {
+		err = convertWrite(out, c, l)
+	}
+	if err != nil {
 		cl.Err(err)
 		cl.Exit(1)
 	}
-	cl.Out(buf.String())
+}
+
+func codec(name string) (fakerpc.Codec, error) {
+	c, ok := fakerpc.CodecByName(name)
+	if !ok {
+		return c, fmt.Errorf("fakerpc: unknown format %q", name)
+	}
+	return c, nil
+}
+
+// convertRead reads a log in the format from the file or the stdin.
+func convertRead(file, format string) (*fakerpc.Log, error) {
+	var (
+		c   fakerpc.Codec
+		ok  bool
+		err error
+	)
+	if format != "" {
+		if c, err = codec(format); err != nil {
+			return nil, err
+		}
+		ok = true
+	} else if file != "" && file != "-" {
+		c, ok = fakerpc.CodecByExt(file)
+	}
+	if file != "" && file != "-" && (!ok || c.Name == "gob") {
+		// ReadLog detects the format by the content and reads bodies kept
+		// in a store or decrypts the log if needed.
+		return fakerpc.ReadLog(file)
+	}
+	var r = bufio.NewReader(os.Stdin)
+	if file != "" && file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = bufio.NewReader(f)
+	}
+	if !ok {
+		p, _ := r.Peek(512)
+		c = fakerpc.DetectCodec(p)
+	}
+	l := fakerpc.NewLog()
+	if err = c.Unmarshal(r, l); err != nil {
+		return nil, fmt.Errorf("fakerpc: error reading %s log: %v", c.Name, err)
+	}
+	return l, nil
+}
+
+func convertWrite(file string, c fakerpc.Codec, l *fakerpc.Log) error {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if err = c.Marshal(f, l); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// differ gives a Differ with ignore rules set by the flags.
+func differ(ctx *cli.Context) *fakerpc.Differ {
+	d := &fakerpc.Differ{}
+	if ignore := ctx.String("ignore"); ignore != "" {
+		d.Ignore = strings.Split(ignore, ",")
+	}
+	if ignore := ctx.String("ignore-field"); ignore != "" {
+		d.IgnoreFields = strings.Split(ignore, ",")
+	}
+	return d
+}
+
+// logfiles expands the args into a list of record-log files, walking
+// directories for *.gzob files and the rotated *.gzob.N ones. If args are
+// empty, it gives the def.
+func logfiles(args []string, def string) ([]string, error) {
+	if len(args) == 0 {
+		return []string{def}, nil
+	}
+	var files []string
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		f, err := fakerpc.LogFiles(arg)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f...)
+	}
+	return files, nil
 }
 
 // Run TODO(rjeczalik): document
@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/rjeczalik/fakerpc"
+
+	"github.com/codegangsta/cli"
+)
+
+// Rm removes from the record-log either a call given by the --conn and --call
+// flags, a whole connection given by the --conn flag alone, or all the calls
+// matching the --path and --method flags. The log is written back atomically
+// the way it was kept.
+func (cl *CLI) Rm(ctx *cli.Context) {
+	file := ctx.GlobalString("log")
+	l, lf, err := fakerpc.ReadLogFile(file)
+	if err != nil {
+		cl.Err(err)
+		cl.Exit(1)
+	}
+	n := len(l.T)
+	switch conn, call := ctx.Int("conn"), ctx.Int("call"); {
+	case conn >= 0 && call >= 0:
+		l, err = l.RemoveCall(conn, call)
+	case conn >= 0:
+		l, err = l.RemoveConn(conn)
+	case ctx.String("path") != "" || ctx.String("method") != "":
+		l = l.Where(fakerpc.Not(predicate(ctx)))
+	default:
+		err = fmt.Errorf("fakerpc: missing (...) rm --conn <index> [--call <index>] or --path/--method")
+	}
+	if err != nil {
+		cl.Err(err)
+		cl.Exit(1)
+	}
+	if err = lf.WriteLog(file, l); err != nil {
+		cl.Err(err)
+		cl.Exit(1)
+	}
+	cl.Out(fmt.Sprintf("fakerpc: removed %d transmission(s) from the %q file", n-len(l.T), file))
+}
+
+// predicate gives a Predicate matching requests by the --path and --method
+// flags.
+func predicate(ctx *cli.Context) fakerpc.Predicate {
+	path, method := ctx.String("path"), ctx.String("method")
+	return func(req *http.Request) bool {
+		if path != "" && !fakerpc.ByPath(path)(req) {
+			return false
+		}
+		return method == "" || fakerpc.ByMethod(strings.Split(method, ",")...)(req)
+	}
+}
+
+// Edit modifies the response, or the request with the --request flag, of
+// a call given by the --conn and --call flags. The body is replaced with
+// content of a file given by the --body flag ("-" reads the stdin), headers
+// are set with the --set flags and removed with the --unset ones. The log is
+// written back atomically the way it was kept.
+func (cl *CLI) Edit(ctx *cli.Context) {
+	file := ctx.GlobalString("log")
+	l, lf, err := fakerpc.ReadLogFile(file)
+	if err != nil {
+		cl.Err(err)
+		cl.Exit(1)
+	}
+	if ctx.Int("conn") < 0 {
+		cl.Err("fakerpc: missing (...) edit --conn <index> [--call <index>]")
+		cl.Exit(1)
+	}
+	call := ctx.Int("call")
+	if call < 0 {
+		call = 0
+	}
+	req, res, err := l.Call(ctx.Int("conn"), call)
+	if err != nil {
+		cl.Err(err)
+		cl.Exit(1)
+	}
+	i := res
+	if ctx.Bool("request") {
+		i = req
+	}
+	if i == -1 {
+		cl.Err("fakerpc: no response recorded for the call")
+		cl.Exit(1)
+	}
+	raw, err := edit(ctx, l.T[i].Raw)
+	if err != nil {
+		cl.Err(err)
+		cl.Exit(1)
+	}
+	l.T[i].Raw = raw
+	if err = lf.WriteLog(file, l); err != nil {
+		cl.Err(err)
+		cl.Exit(1)
+	}
+	cl.Out(fmt.Sprintf("fakerpc: edited transmission %d of the %q file", i, file))
+}
+
+// edit applies changes given by the flags to the raw message.
+func edit(ctx *cli.Context, raw []byte) (p []byte, err error) {
+	p = raw
+	for _, kv := range ctx.StringSlice("set") {
+		i := strings.IndexByte(kv, ':')
+		if i == -1 {
+			return nil, fmt.Errorf("fakerpc: invalid header %q; want \"Key: value\"", kv)
+		}
+		if p, err = fakerpc.SetHeader(p, strings.TrimSpace(kv[:i]), strings.TrimSpace(kv[i+1:])); err != nil {
+			return nil, err
+		}
+	}
+	for _, k := range ctx.StringSlice("unset") {
+		if p, err = fakerpc.SetHeader(p, k, ""); err != nil {
+			return nil, err
+		}
+	}
+	if file := ctx.String("body"); file != "" {
+		var body []byte
+		if file == "-" {
+			body, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			body, err = ioutil.ReadFile(file)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if p, err = fakerpc.SetBody(p, body); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/rjeczalik/fakerpc"
+
+	"github.com/codegangsta/cli"
+)
+
+// Export prints requests recorded in the record-log as curl command lines, or
+// Go snippets with the --format go flag. The calls can be narrowed with
+// the --conn and --call flags, as well as the --path and --method ones.
+// The --target flag sends the requests to another service, and values of
+// the headers given by the --redact flag are replaced with a placeholder.
+func (cl *CLI) Export(ctx *cli.Context) {
+	l, err := fakerpc.ReadLog(ctx.GlobalString("log"))
+	if err != nil {
+		cl.Err(err)
+		cl.Exit(1)
+	}
+	if ctx.String("path") != "" || ctx.String("method") != "" {
+		l = l.Where(predicate(ctx))
+	}
+	conns, err := fakerpc.NewConnections(l)
+	if err != nil {
+		cl.Err(err)
+		cl.Exit(1)
+	}
+	e := &fakerpc.Exporter{Target: ctx.String("target"), Redact: []string{}}
+	for _, k := range strings.Split(ctx.String("redact"), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			e.Redact = append(e.Redact, k)
+		}
+	}
+	var export func(*fakerpc.Connection) string
+	switch format := ctx.String("format"); format {
+	case "curl":
+		export = e.Curl
+	case "go":
+		export = e.GoRequest
+	default:
+		cl.Err(fmt.Sprintf("fakerpc: unknown export format %q; want curl or go", format))
+		cl.Exit(1)
+	}
+	var buf bytes.Buffer
+	conn, call := ctx.Int("conn"), ctx.Int("call")
+	for i := range conns {
+		if conn >= 0 && i != conn {
+			continue
+		}
+		for j := range conns[i] {
+			if call >= 0 && j != call {
+				continue
+			}
+			if buf.Len() != 0 {
+				buf.WriteByte('\n')
+			}
+			buf.WriteString(export(&conns[i][j]))
+		}
+	}
+	if buf.Len() == 0 {
+		cl.Err("fakerpc: no calls to export")
+		cl.Exit(1)
+	}
+	cl.Out(strings.TrimSuffix(buf.String(), "\n"))
+}
@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rjeczalik/fakerpc"
+
+	"github.com/codegangsta/cli"
+)
+
+// GenTest generates a Go test in the dir given by the --dir flag, which replies
+// to calls recorded in the record-log with fakerpc.Fixture. The test function
+// is named by the --name flag, its file by the name without the "Test" prefix
+// and the log is copied to the testdata dir, where Fixture looks for it,
+// without the connections the test does not issue. An encrypted log is copied
+// encrypted with the key it was read with.
+func (cl *CLI) GenTest(ctx *cli.Context) {
+	name, dir := ctx.String("name"), ctx.String("dir")
+	if name == "" {
+		cl.Err("fakerpc: missing (...) gen-test --name TestXxx")
+		cl.Exit(1)
+	}
+	pkg := ctx.String("pkg")
+	if pkg == "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			cl.Err(err)
+			cl.Exit(1)
+		}
+		if pkg = filepath.Base(abs); !token.IsIdentifier(pkg) {
+			cl.Err(fmt.Sprintf("fakerpc: %q is not a valid package name; set it with --pkg", pkg))
+			cl.Exit(1)
+		}
+	}
+	l, lf, err := fakerpc.ReadLogFile(ctx.GlobalString("log"))
+	if err != nil {
+		cl.Err(err)
+		cl.Exit(1)
+	}
+	var buf bytes.Buffer
+	if err = fakerpc.GenTest(&buf, pkg, name, l); err != nil {
+		cl.Err(err)
+		cl.Exit(1)
+	}
+	base := strings.ToLower(strings.TrimPrefix(name, "Test"))
+	if base == "" {
+		base = "fakerpc"
+	}
+	file, logfile := filepath.Join(dir, base+"_test.go"), fakerpc.FixturePath(dir, name)
+	if !ctx.Bool("force") {
+		for _, f := range []string{file, logfile} {
+			if _, err = os.Stat(f); err == nil {
+				cl.Err(fmt.Sprintf("fakerpc: the %q file already exists; use --force to overwrite it", f))
+				cl.Exit(1)
+			}
+		}
+	}
+	if err = os.MkdirAll(filepath.Dir(logfile), 0755); err != nil {
+		cl.Err(err)
+		cl.Exit(1)
+	}
+	if lf.Encrypted {
+		var key string
+		if key, err = fakerpc.EnvKey(); err == nil {
+			err = fakerpc.WriteLogKey(logfile, fakerpc.GenTestLog(l), key)
+		}
+	} else {
+		err = fakerpc.WriteLog(logfile, fakerpc.GenTestLog(l))
+	}
+	if err != nil {
+		cl.Err(err)
+		cl.Exit(1)
+	}
+	if err = ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		cl.Err(err)
+		cl.Exit(1)
+	}
+	cl.Out(fmt.Sprintf("fakerpc: generated %s in the %q file with the log in %q", name, file, logfile))
+}
@@ -0,0 +1,205 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+
+	"github.com/rjeczalik/fakerpc"
+
+	"github.com/codegangsta/cli"
+)
+
+// A service is a Server or a Proxy run by the serve command.
+type service struct {
+	name  string
+	kind  string       // describes the service in messages
+	open  func() error // opens files the service writes to, if any
+	serve func() error
+	addr  func() net.Addr
+	stop  func() error
+	ready chan struct{} // closed when the service is listening
+	exit  chan struct{} // closed when the service is done
+}
+
+// Serve runs all the endpoints described by a config file given by the --config
+// flag, until all the Servers replied with their logs or a signal is caught.
+// Messages of every endpoint are prefixed with its name.
+func (cl *CLI) Serve(ctx *cli.Context) {
+	file := ctx.String("config")
+	if file == "" {
+		cl.Err("fakerpc: missing (...) serve --config <config file>")
+		cl.Exit(1)
+	}
+	cfg, err := fakerpc.ReadConfig(file)
+	if err != nil {
+		cl.Err(err)
+		cl.Exit(1)
+	}
+	svcs := make([]*service, 0, len(cfg.Endpoints))
+	for i := range cfg.Endpoints {
+		e := &cfg.Endpoints[i]
+		var svc *service
+		if e.Mode == "record" {
+			svc, err = cl.recordService(e.String(), e)
+		} else {
+			svc, err = cl.replyService(e.String(), e)
+		}
+		if err != nil {
+			cl.Err(fmt.Sprintf("%s %v", prefix(e.String()), err))
+			cl.Exit(1)
+		}
+		svcs = append(svcs, svc)
+	}
+	// Logs are truncated only after every endpoint was built successfully.
+	for _, svc := range svcs {
+		if svc.open == nil {
+			continue
+		}
+		if err := svc.open(); err != nil {
+			cl.Err(fmt.Sprintf("%s %v", prefix(svc.name), err))
+			cl.Exit(1)
+		}
+	}
+	cl.run(svcs)
+}
+
+// prefix gives a prefix of messages of a service with the name.
+func prefix(name string) string {
+	if name == "" {
+		return "fakerpc:"
+	}
+	return "fakerpc: [" + name + "]"
+}
+
+// replyService gives a service of a Server configured by the e, which
+// messages are prefixed with the name.
+func (cl *CLI) replyService(name string, e *fakerpc.EndpointConfig) (*service, error) {
+	srv, err := e.NewServer()
+	if err != nil {
+		return nil, err
+	}
+	pre := prefix(name)
+	srv.Reply = func(src, dst net.Addr, n int64, err error) {
+		if err != nil {
+			cl.Err(fmt.Sprintf("%s T %s -> %s (%d) error: %v", pre, src, dst, n, err))
+		} else {
+			cl.Out(fmt.Sprintf("%s T %s -> %s (%d)", pre, src, dst, n))
+		}
+	}
+	return &service{
+		name:  name,
+		kind:  "Server replying",
+		serve: srv.ListenAndServe,
+		addr:  srv.Addr,
+		stop: func() error {
+			ctx, cancel := shutdownContext()
+			defer cancel()
+			if err := srv.Shutdown(ctx); err != fakerpc.ErrNotRunning {
+				return err
+			}
+			return nil
+		},
+	}, nil
+}
+
+// recordService gives a service of a Proxy configured by the e, which writes
+// connections to its log as soon as they're closed. The log is created when
+// the service is opened. Its messages are prefixed with the name.
+func (cl *CLI) recordService(name string, e *fakerpc.EndpointConfig) (*service, error) {
+	p, err := e.NewProxy()
+	if err != nil {
+		return nil, err
+	}
+	pre := prefix(name)
+	p.Record = func(t *fakerpc.Transmission) {
+		cl.Out(fmt.Sprintf("%s T %s -> %s (%d)", pre, t.Src, t.Dst, len(t.Raw)))
+	}
+	var lw *fakerpc.LogWriter
+	return &service{
+		name: name,
+		kind: fmt.Sprintf("Proxy recording to the %q file", e.Log),
+		open: func() error {
+			f, err := os.OpenFile(e.Log, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+			if err != nil {
+				return err
+			}
+			lw = fakerpc.NewLogWriter(f)
+			lw.SyncInterval = syncInterval
+			p.Sink = lw
+			return nil
+		},
+		serve: p.ListenAndServe,
+		addr:  p.Addr,
+		stop: func() error {
+			ctx, cancel := shutdownContext()
+			defer cancel()
+			if _, err := p.Shutdown(ctx); err != nil && err != fakerpc.ErrNotRunning {
+				lw.Close()
+				return err
+			}
+			return lw.Close()
+		},
+	}, nil
+}
+
+// run serves all the svcs until all of them are done, any of them fails or
+// a signal is caught, and stops them. It exits with 1 if any failed.
+func (cl *CLI) run(svcs []*service) {
+	var (
+		wg   sync.WaitGroup
+		errc = make(chan error, len(svcs))
+		done = make(chan struct{})
+		sig  = make(chan os.Signal, 1)
+	)
+	signal.Notify(sig, os.Interrupt, os.Kill)
+	for _, svc := range svcs {
+		svc.ready, svc.exit = make(chan struct{}), make(chan struct{})
+		wg.Add(1)
+		go func(svc *service) {
+			defer wg.Done()
+			if err := svc.serve(); err != nil {
+				errc <- fmt.Errorf("%s %v", prefix(svc.name), err)
+			}
+			close(svc.exit)
+		}(svc)
+		go func(svc *service) {
+			cl.Out(fmt.Sprintf("%s %s on %s . . .", prefix(svc.name), svc.kind, svc.addr()))
+			close(svc.ready)
+		}(svc)
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	failed := false
+	select {
+	case <-sig:
+		cl.Out("fakerpc: Signal caught; stopping . . .")
+	case err := <-errc:
+		cl.Err(err)
+		failed = true
+	case <-done:
+	}
+	for _, svc := range svcs {
+		// A service can't be stopped before it starts listening.
+		select {
+		case <-svc.ready:
+		case <-svc.exit:
+		}
+		if err := svc.stop(); err != nil {
+			cl.Err(fmt.Sprintf("%s %v", prefix(svc.name), err))
+			failed = true
+		}
+	}
+	<-done
+	for len(errc) != 0 {
+		cl.Err(<-errc)
+		failed = true
+	}
+	if failed {
+		cl.Exit(1)
+	}
+}
@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/rjeczalik/fakerpc"
+
+	"github.com/codegangsta/cli"
+)
+
+// Show prints calls recorded in the record-log, grouped per connection, with
+// decompressed and pretty-printed bodies. The calls can be filtered with
+// the --conn, --path, --method and --status flags; the --json flag prints
+// them as a JSON array and the --ngrep one - the whole log as a ngrep output.
+func (cl *CLI) Show(ctx *cli.Context) {
+	l, err := fakerpc.ReadLog(ctx.GlobalString("log"))
+	if err != nil {
+		cl.Err(err)
+		cl.Exit(1)
+	}
+	if ctx.Bool("ngrep") {
+		var buf bytes.Buffer
+		if err = fakerpc.NgrepMarshal(&buf, xmlreadable(l)); err != nil {
+			cl.Err(err)
+			cl.Exit(1)
+		}
+		cl.Out(buf.String())
+		return
+	}
+	calls := callFilter(ctx).Calls(l)
+	if ctx.Bool("json") {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "\t")
+		if err = enc.Encode(calls); err != nil {
+			cl.Err(err)
+			cl.Exit(1)
+		}
+		cl.Out(strings.TrimSuffix(buf.String(), "\n"))
+		return
+	}
+	conn := -1
+	for _, c := range calls {
+		if c.Conn != conn {
+			conn = c.Conn
+			cl.Out(fmt.Sprintf("=== conn %d: %s -> %s", c.Conn, c.Src, c.Dst))
+		}
+		cl.Out(c.String())
+	}
+}
+
+// callFilter gives a CallFilter set by the flags.
+func callFilter(ctx *cli.Context) *fakerpc.CallFilter {
+	f := &fakerpc.CallFilter{
+		Path:   ctx.String("path"),
+		Status: ctx.Int("status"),
+	}
+	if n := ctx.Int("conn"); n >= 0 {
+		f.Conns = []int{n}
+	}
+	if method := ctx.String("method"); method != "" {
+		f.Methods = strings.Split(method, ",")
+	}
+	return f
+}
+
+// xmlreadable gives a copy of the l with XML-RPC and SOAP payloads replaced
+// with their human-readable representation.
+func xmlreadable(l *fakerpc.Log) *fakerpc.Log {
+	r := *l
+	r.T = make([]fakerpc.Transmission, len(l.T))
+	for i, t := range l.T {
+		if header, body := fakerpc.SplitHeaderBody(t.Raw); header != nil {
+			if x, err := fakerpc.ParseXMLCall(body); err == nil {
+				t.Raw = append(append([]byte{}, header...), x.String()+"\n"...)
+			}
+		}
+		r.T[i] = t
+	}
+	return &r
+}
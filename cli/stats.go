@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rjeczalik/fakerpc"
+
+	"github.com/codegangsta/cli"
+)
+
+// Stats prints a summary of record-logs given as arguments, or the one set by
+// the --log flag when there are none. Multiple logs are summarized as a single
+// one.
+func (cl *CLI) Stats(ctx *cli.Context) {
+	files, err := logfiles(ctx.Args(), ctx.GlobalString("log"))
+	if err != nil {
+		cl.Err(err)
+		cl.Exit(1)
+	}
+	logs := make([]*fakerpc.Log, 0, len(files))
+	for _, file := range files {
+		l, err := fakerpc.ReadLog(file)
+		if err != nil {
+			cl.Err(fmt.Sprintf("%s: %v", file, err))
+			cl.Exit(1)
+		}
+		logs = append(logs, l)
+	}
+	s := fakerpc.Summarize(fakerpc.MergeLogs(logs...))
+	if n := ctx.Int("top"); n > 0 && len(s.Endpoints) > n {
+		s.Endpoints = s.Endpoints[:n]
+	}
+	if ctx.Bool("json") {
+		p, err := json.MarshalIndent(s, "", "\t")
+		if err != nil {
+			cl.Err(err)
+			cl.Exit(1)
+		}
+		cl.Out(string(p))
+		return
+	}
+	cl.Out(renderStats(s))
+}
+
+func renderStats(s *fakerpc.Stats) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "connections: %d\nrequests:    %d\nbytes:       %d\n", s.Conns, s.Requests, s.Bytes)
+	codes := make([]int, 0, len(s.Status))
+	for code := range s.Status {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	buf.WriteString("\nstatus:\n")
+	for _, code := range codes {
+		if code == 0 {
+			fmt.Fprintf(&buf, "  none  %d\n", s.Status[code])
+		} else {
+			fmt.Fprintf(&buf, "  %d   %d\n", code, s.Status[code])
+		}
+	}
+	w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "\n\tN\tMIN\tP50\tP90\tP99\tMAX")
+	size := func(v int64) string { return fmt.Sprint(v) }
+	lat := func(v int64) string { return time.Duration(v).String() }
+	dist := func(name string, d fakerpc.Dist, f func(int64) string) {
+		if d.N != 0 {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", name, d.N, f(d.Min), f(d.P50), f(d.P90), f(d.P99), f(d.Max))
+		}
+	}
+	dist("request size", s.ReqSize, size)
+	dist("response size", s.ResSize, size)
+	dist("latency", s.Latency, lat)
+	fmt.Fprintln(w, "\nENDPOINT\tCALLS\tREQ P50\tRES P50\tRES MAX\tLAT P50\tLAT P99")
+	for _, ep := range s.Endpoints {
+		latency := [2]string{"-", "-"}
+		if ep.Latency.N != 0 {
+			latency = [2]string{lat(ep.Latency.P50), lat(ep.Latency.P99)}
+		}
+		fmt.Fprintf(w, "%s %s\t%d\t%d\t%d\t%d\t%s\t%s\n", ep.Method, ep.Path, ep.Calls, ep.ReqSize.P50,
+			ep.ResSize.P50, ep.ResSize.Max, latency[0], latency[1])
+	}
+	w.Flush()
+	return strings.TrimRight(buf.String(), "\n")
+}
@@ -14,15 +14,30 @@
 //
 //   $ git clone http://localhost:8079/rjeczalik/fakerpc.git
 //
-// Sending SIGINT fo the fakerpc stops it from recording and saves the transmission
-// history to the log file:
+// Every connection is appended to the log file as soon as it's closed, so the log
+// remains readable even if fakerpc gets killed. Sending SIGINT fo the fakerpc
+// stops it from recording; exchanges in progress are waited for up to 10 seconds,
+// so they're not recorded partially:
 //
 //   $ sudo ./fakerpc --addr localhost:8079 record https://github.com
-//   fakerpc: Proxy recording on 172.17.42.1:80 . . .
+//   fakerpc: Proxy recording on 172.17.42.1:80 to the "/home/rjeczalik/fakerpc.gzob.1" file . . .
 //   fakerpc: T 172.0.0.1:49740 -> 192.30.252.129:80 (169)
 //   fakerpc: T 192.30.252.129:80 -> 172.0.0.1:49740 (217)
 //   ^Cfakerpc: Signal caught; stopping proxy . . .
-//   fakerpc: Saving log to the "/home/rjeczalik/fakerpc.gzob.1" file . . .
+//   fakerpc: Log saved to the "/home/rjeczalik/fakerpc.gzob.1" file
+//
+// For unattended recording sessions the log can be rotated by size, number of
+// connections or time, with the oldest files removed above given retention
+// limits. Rotated logs are numbered like the default log file:
+//
+//   $ fakerpc --log /tmp/api.gzob.0 record --rotate-size 64 --max-files 10 http://api.local
+//
+// Logs containing credentials or personal data can be encrypted at rest with
+// the --encrypt flag. The passphrase is read from the FAKERPC_KEY environment
+// variable or from a file given by FAKERPC_KEY_FILE; every command reading
+// a log decrypts it with the same key:
+//
+//   $ FAKERPC_KEY_FILE=~/.fakerpc.key fakerpc record --encrypt http://api.local
 //
 // The second mode allows for fakerpc acting as a actual fake server - it listens
 // on an address provided by the --addr flag and reads a log file specified by
@@ -33,6 +48,119 @@
 // After cloning a repository from the fake, the server itself will shutdown as
 // soon as the transmission is completed.
 //
+// Several fakes can be run from a single process by giving the reply command
+// addr=log pairs instead. Messages of every server are prefixed with its
+// address; the command returns after all of them replied with their logs,
+// and SIGINT stops all of them at once:
+//
+//   $ fakerpc reply localhost:8081=testdata/users.gzob localhost:8082=testdata/billing.gzob
+//   fakerpc: [localhost:8081] Server replying on 127.0.0.1:8081 . . .
+//   fakerpc: [localhost:8082] Server replying on 127.0.0.1:8082 . . .
+//
+// Services talking over Unix domain sockets are recorded and faked by giving
+// a socket path prefixed with unix:// as the address or the target. Clients of
+// such connections are unnamed, so the log numbers them as @1, @2 and so on:
+//
+//   $ fakerpc --addr unix:///tmp/fake.sock record unix:///run/daemon.sock
+//
+// Mixing recording proxies and fakes, each with its own settings, is possible
+// with the serve command.
+//
+// A whole fake environment can be described by a JSON config file and run
+// with the serve command. Every endpoint either records traffic to its target
+// or replies with its log; recorded headers can be redacted and replies can be
+// delayed or failed with a given rate. Relative paths are resolved against
+// the config's directory, so it can be checked in along with the logs:
+//
+//   $ cat testdata/fake.json
+//   {"endpoints": [
+//     {"name": "users", "mode": "reply", "addr": "localhost:8081", "log": "users.gzob",
+//      "match": "request", "latency": "100ms", "jitter": "50ms", "fault": {"rate": 0.05, "status": 503}},
+//     {"name": "billing", "mode": "record", "addr": "localhost:8082", "log": "billing.gzob",
+//      "target": "https://billing.local", "redact": ["Authorization", "Cookie"],
+//      "tls": {"cert": "cert.pem", "key": "key.pem"}}
+//   ]}
+//   $ fakerpc serve --config testdata/fake.json
+//   fakerpc: [users] Server replying on 127.0.0.1:8081 . . .
+//   fakerpc: [billing] Proxy recording to the "testdata/billing.gzob" file on 127.0.0.1:8082 . . .
+//
+// The show command prints recorded calls per connection, with decompressed
+// bodies, JSON and XML ones pretty-printed and binary ones hex-dumped. Calls can
+// be filtered by a connection index, a path pattern, a method or a status;
+// the --json flag prints them in a machine-readable form and the --ngrep one
+// prints the whole log as a ngrep output:
+//
+//   $ fakerpc --log testdata/user.gzob show --path '/api/*' --status 500
+//
+// A single call can be fixed without re-recording the whole log. The rm command
+// removes a call or a connection, addressed by indices printed by show, or
+// the calls matching a path or a method; the edit command replaces a body or
+// headers of a response, or of a request with the --request flag. Both write
+// the log back atomically:
+//
+//   $ fakerpc --log testdata/user.gzob rm --conn 2 --call 0
+//   $ fakerpc --log testdata/user.gzob edit --conn 0 --call 1 --body fixed.json --set 'Content-Type: application/json'
+//
+// The export command prints recorded requests as curl command lines, or Go
+// snippets building a *http.Request with the --format go flag, to reproduce
+// a single call by hand. The --target flag sends them to another service;
+// values of Authorization, Cookie and similar headers are redacted:
+//
+//   $ fakerpc --log testdata/user.gzob export --conn 0 --call 1 --target http://staging.local
+//   curl -X POST 'http://staging.local/api/user' \
+//     -H 'Authorization: REDACTED' \
+//     -H 'Content-Type: application/json' \
+//     --data-binary '{"name":"rjeczalik"}'
+//
+// The gen-test command bootstraps a Go test from a record-log. It copies the log
+// to the testdata dir of the package and writes a _test.go file with a test,
+// which starts the fake with fakerpc.Fixture and issues every recorded request
+// with a http.Client, checking statuses of the responses:
+//
+//   $ fakerpc --log /tmp/user.gzob gen-test --dir ./api --name TestUser
+//   fakerpc: generated TestUser in the "api/user_test.go" file with the log in "api/testdata/testuser.gzob"
+//
+// The lint command reports every problem, which would make a record-log unable
+// to replay, like incomplete bodies or requests without responses. It accepts
+// both files and directories, which makes it usable in CI:
+//
+//   $ fakerpc lint ./testdata
+//
+// Record-logs are versioned; the upgrade command rewrites logs written by older
+// versions of fakerpc in the newest format:
+//
+//   $ fakerpc upgrade ./testdata
+//
+// The diff command compares calls recorded in two logs, e.g. after re-recording
+// fixtures. Calls are paired by a matcher, and every added, removed or modified
+// call is reported with differences in its status, headers and body; JSON
+// bodies are compared structurally. It exits with 1 if the logs differ:
+//
+//   $ fakerpc diff testdata/old.gzob testdata/new.gzob
+//   ~ GET /api/user
+//       status: 200 OK -> 404 Not Found
+//       body.name: "rjeczalik" -> null
+//
+// The verify command turns record-logs into lightweight contract tests - it
+// replays every recorded request against a live service and compares its
+// responses with the recorded ones, exiting with 1 if any differs. Volatile
+// headers and JSON fields can be ignored:
+//
+//   $ fakerpc --log testdata/user.gzob verify --ignore-field id,created http://staging.local
+//
+// The stats command summarizes record-logs - numbers of connections and
+// requests, distribution of statuses, percentiles of sizes and, for logs
+// recorded by fakerpc, of latencies, overall and per endpoint:
+//
+//   $ fakerpc stats --top 10 ./testdata
+//
+// The convert command converts logs between the gob, ngrep and json formats.
+// Formats are detected by file extensions or the content, or set explicitly
+// with the --from and --to flags; a "-" stands for the stdin or the stdout:
+//
+//   $ fakerpc convert capture.ngrep testdata/user.gzob
+//   $ fakerpc convert --to json testdata/user.gzob - | jq .
+//
 // Usage:
 //
 //   NAME:
@@ -46,12 +174,23 @@
 //
 //   COMMANDS:
 //      record       Proxies connections recording them all to the record-log
-//      reply        Serves connections with recorded responses from the record-log
-//      show         Shows record-log as a ngrep output
+//      reply        Serves connections with recorded responses from the record-log, or from many given as addr=log
+//      serve        Runs record and reply endpoints described by a JSON config file
+//      show         Shows calls of the record-log per connection, with decoded and pretty-printed bodies
+//      lint         Reports problems found in the record-logs, either files or testdata dirs
+//      upgrade      Rewrites record-logs in place in the newest format, either files or testdata dirs
+//      diff         Compares calls recorded in two record-logs, exiting with 1 if they differ
+//      verify       Replays the record-log against a live service, exiting with 1 if responses differ
+//      convert      Converts a record-log between formats: gob, ngrep or json; - stands for stdin or stdout
+//      stats        Summarizes calls, statuses, sizes and latencies of the record-logs, either files or dirs
+//      rm           Removes a call, a connection or the matching calls from the record-log in place
+//      edit         Replaces a body or headers of a recorded response or request in place
+//      export       Prints recorded requests as curl command lines or Go snippets, with sensitive headers redacted
+//      gen-test     Generates a Go test replying to the record-log with fakerpc.Fixture
 //      help, h      Shows a list of commands or help for one command
 //
 //   GLOBAL OPTIONS:
-//      --addr 'localhost:0'              An address to listen on
+//      --addr 'localhost:0'              An address to listen on, or unix://<path> of a Unix domain socket
 //      --log '${HOME}/fakerpc.gzob.0'    A path to the record-log file (or ngrep output)
 //      --version, -v                     print the version
 //      --help, -h                        show help
@@ -0,0 +1,86 @@
+package fakerpc
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// A Codec reads and writes logs in a single format.
+type Codec struct {
+	Name      string                          // name of the format
+	Exts      []string                        // file extensions of the format
+	Marshal   func(w io.Writer, l *Log) error // writes the l in the format
+	Unmarshal func(r io.Reader, l *Log) error // reads a log in the format into the l
+}
+
+// Codecs lists all the formats logs can be converted between.
+var Codecs = []Codec{{
+	Name:      "gob",
+	Exts:      []string{".gzob"},
+	Marshal:   EncodeLog,
+	Unmarshal: gobUnmarshal,
+}, {
+	Name:      "ngrep",
+	Exts:      []string{".ngrep", ".txt"},
+	Marshal:   NgrepMarshal,
+	Unmarshal: NgrepUnmarshal,
+}, {
+	Name:      "json",
+	Exts:      []string{".json"},
+	Marshal:   JSONMarshal,
+	Unmarshal: JSONUnmarshal,
+}}
+
+func gobUnmarshal(r io.Reader, l *Log) error {
+	dl, _, err := DecodeLog(r)
+	if err != nil {
+		return err
+	}
+	*l = *dl
+	return nil
+}
+
+// CodecByName gives a codec of the named format.
+func CodecByName(name string) (Codec, bool) {
+	for _, c := range Codecs {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Codec{}, false
+}
+
+// CodecByExt gives a codec of the format, which the file's extension belongs
+// to. Numbered files, like the ones written by a LogRotator, are looked up by
+// the extension preceding the number.
+func CodecByExt(file string) (Codec, bool) {
+	ext := filepath.Ext(file)
+	if strings.Trim(ext, ".0123456789") == "" {
+		ext = filepath.Ext(strings.TrimSuffix(file, ext))
+	}
+	for _, c := range Codecs {
+		for _, e := range c.Exts {
+			if strings.EqualFold(e, ext) {
+				return c, true
+			}
+		}
+	}
+	return Codec{}, false
+}
+
+// DetectCodec gives a codec of the format the p, which is a beginning of
+// a log, is written in. A text, which is not a JSON document, is assumed to be
+// a ngrep output.
+func DetectCodec(p []byte) Codec {
+	name := "ngrep"
+	switch {
+	case bytes.HasPrefix(p, logMagic) || bytes.HasPrefix(p, sealMagic) || bytes.HasPrefix(p, gzipMagic):
+		name = "gob"
+	case bytes.HasPrefix(bytes.TrimSpace(p), []byte("{")):
+		name = "json"
+	}
+	c, _ := CodecByName(name)
+	return c
+}
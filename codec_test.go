@@ -0,0 +1,84 @@
+package fakerpc
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJSONMarshal(t *testing.T) {
+	l := log.Map(func(tr Transmission) Transmission {
+		tr.Time = time.Unix(1400000000, 0).UTC()
+		return tr
+	})
+	l.Networks, l.Filter = lexp.Networks, lexp.Filter
+	l.T[0].Raw = []byte{0xff, 0xfe, '\r', '\n'}
+	var buf bytes.Buffer
+	if err := JSONMarshal(&buf, l); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	jl := NewLog()
+	if err := JSONUnmarshal(&buf, jl); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if jl.Filter != l.Filter || !equalStrings(jl.Net(), l.Net()) {
+		t.Errorf("expected Filter=%q, Net()=%v; got %q, %v", l.Filter, l.Net(), jl.Filter, jl.Net())
+	}
+	if len(jl.T) != len(l.T) {
+		t.Fatalf("expected len(jl.T)=%d; got %d", len(l.T), len(jl.T))
+	}
+	for i := range l.T {
+		if !bytes.Equal(jl.T[i].Raw, l.T[i].Raw) || !addrequal(jl.T[i].Src, l.T[i].Src) ||
+			!addrequal(jl.T[i].Dst, l.T[i].Dst) || !jl.T[i].Time.Equal(l.T[i].Time) {
+			t.Errorf("expected jl.T[%d]=%v; got %v", i, l.T[i], jl.T[i])
+		}
+	}
+}
+
+func TestCodec(t *testing.T) {
+	cases := [...]struct {
+		file string
+		p    []byte
+		exp  string
+	}{
+		{"log.gzob", logMagic, "gob"},
+		{"log.gzob.12", sealMagic, "gob"},
+		{"capture.ngrep", ngrep, "ngrep"},
+		{"log.json", []byte("\n\t{\"transmissions\": []}"), "json"},
+	}
+	for i, cas := range cases {
+		c, ok := CodecByExt(cas.file)
+		if !ok || c.Name != cas.exp {
+			t.Errorf("expected CodecByExt=%q; got %q, %v (i=%d)", cas.exp, c.Name, ok, i)
+		}
+		if c = DetectCodec(cas.p); c.Name != cas.exp {
+			t.Errorf("expected DetectCodec=%q; got %q (i=%d)", cas.exp, c.Name, i)
+		}
+	}
+	if _, ok := CodecByExt("log"); ok {
+		t.Error("expected ok=false for a file without extension")
+	}
+	for _, c := range Codecs {
+		if c.Name == "ngrep" {
+			// ngrep output neither keeps time nor distinguishes line endings.
+			continue
+		}
+		var buf bytes.Buffer
+		if err := c.Marshal(&buf, log); err != nil {
+			t.Fatalf("expected err=nil; got %q (codec=%s)", err, c.Name)
+		}
+		l := NewLog()
+		if err := c.Unmarshal(&buf, l); err != nil {
+			t.Fatalf("expected err=nil; got %q (codec=%s)", err, c.Name)
+		}
+		if len(l.T) != len(log.T) {
+			t.Errorf("expected len(l.T)=%d; got %d (codec=%s)", len(log.T), len(l.T), c.Name)
+		}
+		for i := range l.T {
+			if !reflect.DeepEqual(l.T[i].Raw, log.T[i].Raw) {
+				t.Errorf("expected l.T[%d].Raw=%q; got %q (codec=%s)", i, log.T[i].Raw, l.T[i].Raw, c.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package fakerpc
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+// A Duration is a time.Duration, which is encoded in JSON as a string like
+// "150ms".
+type Duration time.Duration
+
+// MarshalJSON implements the json.Marshaler interface.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (d *Duration) UnmarshalJSON(p []byte) error {
+	var s string
+	if err := json.Unmarshal(p, &s); err != nil {
+		return fmt.Errorf("fakerpc: invalid duration %s", p)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("fakerpc: invalid duration %q", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// A CertConfig names PEM-encoded files of a TLS certificate and its key.
+type CertConfig struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+// An EndpointConfig describes a single Proxy or Server of a Config.
+type EndpointConfig struct {
+	// Name identifies the endpoint in messages; the Addr is used if empty.
+	Name string `json:"name,omitempty"`
+	// Mode is either "record" for a Proxy or "reply" for a Server.
+	Mode string `json:"mode"`
+	// Addr is a TCP network address the endpoint listens on, or a path of
+	// a Unix domain socket prefixed with "unix://".
+	Addr string `json:"addr"`
+	// Log is a record-log the Proxy records to or the Server replies with.
+	Log string `json:"log"`
+	// Target is an URL the Proxy records traffic to, or a "unix://" path of
+	// a Unix domain socket; record mode only.
+	Target string `json:"target,omitempty"`
+	// Match is a name of one of the Matchers the Server looks responses up
+	// with; reply mode only.
+	Match string `json:"match,omitempty"`
+	// Redact is a list of headers redacted in the recorded log; record mode
+	// only.
+	Redact []string `json:"redact,omitempty"`
+	// TLS, when non-nil, makes the endpoint serve TLS connections.
+	TLS *CertConfig `json:"tls,omitempty"`
+	// Latency and Jitter delay responses of the Server; reply mode only.
+	Latency Duration `json:"latency,omitempty"`
+	Jitter  Duration `json:"jitter,omitempty"`
+	// Fault makes the Server fail a part of its responses; reply mode only.
+	Fault *Fault `json:"fault,omitempty"`
+}
+
+// String gives the name of the e, or its address if it has none.
+func (e *EndpointConfig) String() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return e.Addr
+}
+
+// A Config describes a fake environment - a set of endpoints, which either
+// record traffic to their targets or reply with recorded logs. It's meant to be
+// checked in along with the logs, so relative paths are resolved against
+// the directory of the config file.
+type Config struct {
+	Endpoints []EndpointConfig `json:"endpoints"`
+}
+
+// ReadConfig reads a Config from the JSON file and validates it. Unknown fields
+// are reported as errors.
+func ReadConfig(file string) (*Config, error) {
+	p, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.DisallowUnknownFields()
+	var cfg Config
+	if err = dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("fakerpc: %s: %v", file, err)
+	}
+	dir := filepath.Dir(file)
+	for i := range cfg.Endpoints {
+		e := &cfg.Endpoints[i]
+		e.Log = resolve(dir, e.Log)
+		if e.TLS != nil {
+			e.TLS.Cert, e.TLS.Key = resolve(dir, e.TLS.Cert), resolve(dir, e.TLS.Key)
+		}
+	}
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// resolve gives the path relative to the dir, unless it's empty or absolute.
+func resolve(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// Validate reports the first problem found in the cfg, like a missing address,
+// a setting not applicable to the mode of an endpoint or a log recorded to by
+// one endpoint and used by another one.
+func (cfg *Config) Validate() error {
+	if len(cfg.Endpoints) == 0 {
+		return fmt.Errorf("fakerpc: no endpoints configured")
+	}
+	names := make(map[string]struct{})
+	logs := make(map[string]*EndpointConfig)
+	for i := range cfg.Endpoints {
+		e := &cfg.Endpoints[i]
+		if e.Addr == "" {
+			return fmt.Errorf("fakerpc: endpoint %d: missing addr", i)
+		}
+		if _, ok := names[e.String()]; ok {
+			return fmt.Errorf("fakerpc: endpoint %d: duplicate name %q", i, e.String())
+		}
+		names[e.String()] = struct{}{}
+		if err := e.validate(); err != nil {
+			return fmt.Errorf("fakerpc: endpoint %q: %v", e, err)
+		}
+		log := filepath.Clean(e.Log)
+		if other, ok := logs[log]; ok && (e.Mode == "record" || other.Mode == "record") {
+			return fmt.Errorf("fakerpc: endpoint %q: log %q is used by endpoint %q", e, e.Log, other)
+		}
+		logs[log] = e
+	}
+	return nil
+}
+
+func (e *EndpointConfig) validate() error {
+	if e.Log == "" {
+		return fmt.Errorf("missing log")
+	}
+	if e.TLS != nil && (e.TLS.Cert == "" || e.TLS.Key == "") {
+		return fmt.Errorf("tls requires both cert and key")
+	}
+	switch e.Mode {
+	case "record":
+		if e.Target == "" {
+			return fmt.Errorf("missing target")
+		}
+		if e.Match != "" || e.Latency != 0 || e.Jitter != 0 || e.Fault != nil {
+			return fmt.Errorf("match, latency, jitter and fault apply to reply mode only")
+		}
+	case "reply":
+		if e.Target != "" || e.Redact != nil {
+			return fmt.Errorf("target and redact apply to record mode only")
+		}
+		if _, ok := Matchers[e.Match]; e.Match != "" && !ok {
+			return fmt.Errorf("unknown matcher %q", e.Match)
+		}
+		if e.Latency < 0 || e.Jitter < 0 {
+			return fmt.Errorf("negative latency or jitter")
+		}
+		if e.Fault != nil && (e.Fault.Rate < 0 || e.Fault.Rate > 1) {
+			return fmt.Errorf("fault rate %v is out of [0, 1] range", e.Fault.Rate)
+		}
+	default:
+		return fmt.Errorf("unknown mode %q; want record or reply", e.Mode)
+	}
+	return nil
+}
+
+// tlsConfig gives a tls.Config with the e's certificate, or nil if TLS is not
+// configured.
+func (e *EndpointConfig) tlsConfig() (*tls.Config, error) {
+	if e.TLS == nil {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(e.TLS.Cert, e.TLS.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// NewServer gives a Server configured by the e, replying with its log.
+func (e *EndpointConfig) NewServer() (*Server, error) {
+	if e.Mode != "reply" {
+		return nil, fmt.Errorf("fakerpc: endpoint %q is not in reply mode", e)
+	}
+	l, err := ReadLog(e.Log)
+	if err != nil {
+		return nil, err
+	}
+	srv, err := NewServer(e.Addr, l)
+	if err != nil {
+		return nil, err
+	}
+	if srv.TLSConfig, err = e.tlsConfig(); err != nil {
+		return nil, err
+	}
+	srv.Match = Matchers[e.Match]
+	srv.Latency, srv.Jitter, srv.Fault = time.Duration(e.Latency), time.Duration(e.Jitter), e.Fault
+	return srv, nil
+}
+
+// NewProxy gives a Proxy configured by the e. Its Sink is left for the caller
+// to set, as the caller owns the log file.
+func (e *EndpointConfig) NewProxy() (*Proxy, error) {
+	if e.Mode != "record" {
+		return nil, fmt.Errorf("fakerpc: endpoint %q is not in record mode", e)
+	}
+	p, err := NewProxy(e.Addr, e.Target)
+	if err != nil {
+		return nil, err
+	}
+	if p.TLSConfig, err = e.tlsConfig(); err != nil {
+		return nil, err
+	}
+	p.Redact = e.Redact
+	return p, nil
+}
@@ -0,0 +1,140 @@
+package fakerpc
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakerpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "fake.json")
+	cfg := `{"endpoints": [
+		{"name": "users", "mode": "reply", "addr": "localhost:0", "log": "testdata/users.gzob",
+		 "match": "request", "latency": "150ms", "fault": {"rate": 0.1, "status": 503}},
+		{"mode": "record", "addr": "localhost:8079", "log": "/tmp/api.gzob", "target": "http://api.local",
+		 "redact": ["Authorization"], "tls": {"cert": "cert.pem", "key": "key.pem"}}
+	]}`
+	if err = ioutil.WriteFile(file, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := ReadConfig(file)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	exp := &Config{Endpoints: []EndpointConfig{{
+		Name: "users", Mode: "reply", Addr: "localhost:0", Log: filepath.Join(dir, "testdata", "users.gzob"),
+		Match: "request", Latency: Duration(150 * time.Millisecond), Fault: &Fault{Rate: 0.1, Status: 503},
+	}, {
+		Mode: "record", Addr: "localhost:8079", Log: "/tmp/api.gzob", Target: "http://api.local",
+		Redact: []string{"Authorization"},
+		TLS:    &CertConfig{Cert: filepath.Join(dir, "cert.pem"), Key: filepath.Join(dir, "key.pem")},
+	}}}
+	if !reflect.DeepEqual(c, exp) {
+		t.Errorf("expected c=%+v; got %+v", exp, c)
+	}
+	if s := c.Endpoints[1].String(); s != "localhost:8079" {
+		t.Errorf("expected s=localhost:8079; got %q", s)
+	}
+	cases := [...]string{
+		`{"endpoints": []}`,
+		`{"endpoints": [{"mode": "reply", "log": "x.gzob"}]}`,
+		`{"endpoints": [{"mode": "serve", "addr": ":0", "log": "x.gzob"}]}`,
+		`{"endpoints": [{"mode": "record", "addr": ":0", "log": "x.gzob"}]}`,
+		`{"endpoints": [{"mode": "reply", "addr": ":0", "log": "x.gzob", "match": "json"}]}`,
+		`{"endpoints": [{"mode": "reply", "addr": ":0", "log": "x.gzob", "redact": ["Cookie"]}]}`,
+		`{"endpoints": [{"mode": "reply", "addr": ":0", "log": "x.gzob", "fault": {"rate": 2}}]}`,
+		`{"endpoints": [{"mode": "reply", "addr": ":0", "log": "x.gzob", "latency": "1 second"}]}`,
+		`{"endpoints": [{"mode": "reply", "addr": ":0", "log": "x.gzob", "delay": "1s"}]}`,
+		`{"endpoints": [{"mode": "reply", "addr": ":0", "log": "x.gzob"}, {"mode": "reply", "addr": ":0", "log": "y.gzob"}]}`,
+		`{"endpoints": [{"name": "a", "mode": "record", "addr": ":0", "log": "x.gzob", "target": "http://a"},
+		 {"name": "b", "mode": "reply", "addr": ":0", "log": "./x.gzob"}]}`,
+		`{"endpoints": [{"name": "a", "mode": "record", "addr": ":0", "log": "x.gzob", "target": "http://a"},
+		 {"name": "b", "mode": "record", "addr": ":0", "log": "x.gzob", "target": "http://b"}]}`,
+	}
+	for i, cas := range cases {
+		if err = ioutil.WriteFile(file, []byte(cas), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = ReadConfig(file); err == nil {
+			t.Errorf("expected err!=nil (i=%d)", i)
+		}
+	}
+}
+
+func TestServerFault(t *testing.T) {
+	l := calllog(
+		"GET /a HTTP/1.1\r\nHost: api.local\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n",
+		"GET /b HTTP/1.1\r\nHost: api.local\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n",
+	)
+	for i, fault := range []*Fault{{Rate: 1, Status: 503}, {Rate: 1}} {
+		srv, err := NewServer("localhost:0", l)
+		if err != nil {
+			t.Fatalf("expected err=nil; got %q (i=%d)", err, i)
+		}
+		srv.Latency, srv.Fault = 10*time.Millisecond, fault
+		faults := make(chan error, 8)
+		srv.Reply = func(_, _ net.Addr, _ int64, err error) {
+			if err != nil {
+				faults <- err
+			}
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- srv.ListenAndServe()
+		}()
+		tr := &http.Transport{}
+		client := &http.Client{Transport: tr}
+		addr := "http://" + srv.Addr().String()
+		start := time.Now()
+		for _, path := range []string{"/a", "/b"} {
+			res, err := client.Get(addr + path)
+			if fault.Status == 0 {
+				if err == nil {
+					res.Body.Close()
+					t.Errorf("expected err!=nil (i=%d)", i)
+				} else if !strings.Contains(err.Error(), "reset") {
+					t.Errorf("expected connection reset; got %q (i=%d)", err, i)
+				}
+				break
+			}
+			if err != nil {
+				t.Fatalf("expected err=nil; got %q (i=%d)", err, i)
+			}
+			res.Body.Close()
+			if res.StatusCode != 503 {
+				t.Errorf("expected StatusCode=503; got %d (i=%d)", res.StatusCode, i)
+			}
+		}
+		if d := time.Since(start); d < 10*time.Millisecond {
+			t.Errorf("expected responses to be delayed; took %v (i=%d)", d, i)
+		}
+		tr.CloseIdleConnections()
+		if err = <-done; err != nil {
+			t.Errorf("expected err=nil; got %q (i=%d)", err, i)
+		}
+		close(faults)
+		var n int
+		for err := range faults {
+			if err != errFault {
+				t.Errorf("expected err=%q; got %q (i=%d)", errFault, err, i)
+			}
+			n++
+		}
+		if exp := 2 - i; n != exp {
+			t.Errorf("expected %d faults reported; got %d (i=%d)", exp, n, i)
+		}
+	}
+}
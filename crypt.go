@@ -0,0 +1,271 @@
+package fakerpc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// ErrNoKey is returned when reading an encrypted log without a key.
+var ErrNoKey = errors.New("fakerpc: log is encrypted; set FAKERPC_KEY or FAKERPC_KEY_FILE")
+
+var errDecrypt = errors.New("fakerpc: unable to decrypt log: wrong key or corrupted data")
+
+// sealMagic begins every encrypted log; it's followed by a big-endian uint32
+// number of PBKDF2 iterations and a salt.
+var sealMagic = []byte("fakerpc\x01")
+
+const (
+	saltLen  = 16
+	sealHdr  = 8 + 4 + saltLen
+	maxChunk = 1 << 30
+)
+
+// pbkdf2Iter is a number of PBKDF2 iterations a key is derived with.
+var pbkdf2Iter = 600000
+
+// EnvKey gives a passphrase, which logs are encrypted with. It's read either
+// from the FAKERPC_KEY environment variable or from a file the FAKERPC_KEY_FILE
+// variable points to. It gives empty string if neither is set.
+func EnvKey() (string, error) {
+	if key := os.Getenv("FAKERPC_KEY"); key != "" {
+		return key, nil
+	}
+	if file := os.Getenv("FAKERPC_KEY_FILE"); file != "" {
+		p, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(p), "\r\n"), nil
+	}
+	return "", nil
+}
+
+func newGCM(key string, salt []byte, iter int) (cipher.AEAD, error) {
+	k, err := pbkdf2.Key(sha256.New, key, salt, iter, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// A sealWriter encrypts every write as a separate chunk.
+type sealWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	hdr    []byte
+	n      uint64
+	sealed bool
+}
+
+// Encrypt gives a writer, which encrypts everything written to the w with
+// AES-GCM, using a key derived from the passphrase key. Every write is sealed
+// as a separate chunk, so the data written so far can be decrypted even if
+// the writer was never closed, e.g. when used by a LogWriter. Close seals
+// a final empty chunk, which tells a complete stream from one cut at a chunk
+// boundary. The writer passes Sync and Close calls to the w, if it has such
+// methods.
+func Encrypt(w io.Writer, key string) (io.WriteCloser, error) {
+	if key == "" {
+		return nil, errors.New("fakerpc: empty encryption key")
+	}
+	hdr := make([]byte, sealHdr)
+	copy(hdr, sealMagic)
+	binary.BigEndian.PutUint32(hdr[8:], uint32(pbkdf2Iter))
+	if _, err := rand.Read(hdr[12:]); err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key, hdr[12:], pbkdf2Iter)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return &sealWriter{w: w, aead: aead, hdr: hdr}, nil
+}
+
+// nonce gives a nonce for the n-th chunk.
+func nonce(aead cipher.AEAD, n uint64) []byte {
+	p := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(p[len(p)-8:], n)
+	return p
+}
+
+// aad gives additional data authenticated with a chunk; the final chunk is
+// told apart from the others by a flag following the hdr.
+func aad(hdr []byte, final bool) []byte {
+	if !final {
+		return hdr
+	}
+	return append(append([]byte{}, hdr...), 1)
+}
+
+func (sw *sealWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if len(p) > maxChunk {
+		return 0, errors.New("fakerpc: write is too large to encrypt")
+	}
+	if sw.sealed {
+		return 0, errors.New("fakerpc: write to a closed encrypted stream")
+	}
+	if err := sw.seal(p, false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// seal writes the p as the next chunk.
+func (sw *sealWriter) seal(p []byte, final bool) error {
+	buf := make([]byte, 4, 4+len(p)+sw.aead.Overhead())
+	buf = sw.aead.Seal(buf, nonce(sw.aead, sw.n), p, aad(sw.hdr, final))
+	binary.BigEndian.PutUint32(buf, uint32(len(buf)-4))
+	if _, err := sw.w.Write(buf); err != nil {
+		return err
+	}
+	sw.n++
+	return nil
+}
+
+func (sw *sealWriter) Sync() error {
+	if s, ok := sw.w.(interface {
+		Sync() error
+	}); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+func (sw *sealWriter) Close() error {
+	var err error
+	if !sw.sealed {
+		sw.sealed = true
+		err = sw.seal(nil, true)
+	}
+	if c, ok := sw.w.(io.Closer); ok {
+		if e := c.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// An openReader decrypts chunks written by a sealWriter.
+type openReader struct {
+	r    *bufio.Reader
+	aead cipher.AEAD
+	hdr  []byte
+	n    uint64
+	buf  []byte
+	err  error
+}
+
+// IsEncrypted reports whether the r begins with an encrypted log.
+func IsEncrypted(r *bufio.Reader) bool {
+	p, _ := r.Peek(len(sealMagic))
+	return bytes.Equal(p, sealMagic)
+}
+
+// Decrypt gives a reader, which decrypts data written by a writer returned by
+// Encrypt. It verifies the key by decrypting the first chunk. A stream, which
+// ends before the final chunk written by Close, is reported with
+// io.ErrUnexpectedEOF, a tampered one - with an error.
+func Decrypt(r io.Reader, key string) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	hdr := make([]byte, sealHdr)
+	if _, err := io.ReadFull(br, hdr); err != nil || !bytes.Equal(hdr[:8], sealMagic) {
+		return nil, errors.New("fakerpc: not an encrypted log")
+	}
+	if key == "" {
+		return nil, ErrNoKey
+	}
+	aead, err := newGCM(key, hdr[12:], int(binary.BigEndian.Uint32(hdr[8:])))
+	if err != nil {
+		return nil, err
+	}
+	or := &openReader{r: br, aead: aead, hdr: hdr}
+	if or.err = or.next(); or.err == errDecrypt {
+		return nil, or.err
+	}
+	return or, nil
+}
+
+// next reads and decrypts the next chunk. It gives io.EOF after the final
+// chunk, which is the only empty one.
+func (or *openReader) next() error {
+	var size [4]byte
+	if _, err := io.ReadFull(or.r, size[:]); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxChunk+uint32(or.aead.Overhead()) {
+		return errDecrypt
+	}
+	p := make([]byte, n)
+	if _, err := io.ReadFull(or.r, p); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	final := n == uint32(or.aead.Overhead())
+	p, err := or.aead.Open(p[:0], nonce(or.aead, or.n), p, aad(or.hdr, final))
+	if err != nil {
+		return errDecrypt
+	}
+	if final {
+		if _, err = or.r.Peek(1); err != io.EOF {
+			return errDecrypt
+		}
+		return io.EOF
+	}
+	or.buf, or.n = p, or.n+1
+	return nil
+}
+
+func (or *openReader) Read(p []byte) (int, error) {
+	for len(or.buf) == 0 {
+		if or.err != nil {
+			return 0, or.err
+		}
+		or.err = or.next()
+	}
+	n := copy(p, or.buf)
+	or.buf = or.buf[n:]
+	return n, nil
+}
+
+// WriteLogKey writes the Log to the file like WriteLog, encrypting it with
+// a key derived from the passphrase key. ReadLog decrypts such logs with a key
+// given by EnvKey.
+func WriteLogKey(file string, l *Log, key string) error {
+	return writeAtomic(file, func(w io.Writer) error {
+		// The w is hidden behind a struct, so closing the ew seals the stream
+		// without closing the file, which writeAtomic does on its own.
+		ew, err := Encrypt(struct{ io.Writer }{w}, key)
+		if err != nil {
+			return err
+		}
+		if err = EncodeLog(ew, l); err == nil {
+			err = ew.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("fakerpc: error writing encrypted log: %v", err)
+		}
+		return nil
+	})
+}
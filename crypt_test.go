@@ -0,0 +1,228 @@
+package fakerpc
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func init() {
+	pbkdf2Iter = 1000
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := Encrypt(&buf, "secret")
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	chunks := []string{"GET / HTTP/1.1\r\n", "\r\n", "HTTP/1.1 200 OK\r\n\r\n"}
+	var n []int
+	for _, chunk := range chunks {
+		if _, err = io.WriteString(w, chunk); err != nil {
+			t.Fatalf("expected err=nil; got %q", err)
+		}
+		n = append(n, buf.Len())
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("HTTP")) {
+		t.Fatal("expected the data to be encrypted")
+	}
+	all := chunks[0] + chunks[1] + chunks[2]
+	cases := [...]struct {
+		size int
+		exp  string
+		err  error
+	}{
+		{buf.Len(), all, nil},
+		{n[2], all, io.ErrUnexpectedEOF},
+		{n[2] - 1, chunks[0] + chunks[1], io.ErrUnexpectedEOF},
+		{n[0], chunks[0], io.ErrUnexpectedEOF},
+	}
+	for i, cas := range cases {
+		r, err := Decrypt(bytes.NewReader(buf.Bytes()[:cas.size]), "secret")
+		if err != nil {
+			t.Errorf("expected err=nil; got %q (i=%d)", err, i)
+			continue
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != cas.err {
+			t.Errorf("expected err=%v; got %v (i=%d)", cas.err, err, i)
+		}
+		if string(p) != cas.exp {
+			t.Errorf("expected p=%q; got %q (i=%d)", cas.exp, p, i)
+		}
+	}
+	if _, err = Decrypt(bytes.NewReader(buf.Bytes()), "guess"); err != errDecrypt {
+		t.Errorf("expected err=errDecrypt; got %v", err)
+	}
+	if _, err = Decrypt(bytes.NewReader(buf.Bytes()), ""); err != ErrNoKey {
+		t.Errorf("expected err=ErrNoKey; got %v", err)
+	}
+	p := append([]byte{}, buf.Bytes()...)
+	p[n[0]+10] ^= 0xff
+	r, err := Decrypt(bytes.NewReader(p), "secret")
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if _, err = ioutil.ReadAll(r); err != errDecrypt {
+		t.Errorf("expected err=errDecrypt; got %v", err)
+	}
+	// Chunks can be replayed neither before nor after the final one.
+	p = append(append([]byte{}, buf.Bytes()[:n[2]]...), buf.Bytes()[n[1]:n[2]]...)
+	if r, err = Decrypt(bytes.NewReader(p), "secret"); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if _, err = ioutil.ReadAll(r); err != errDecrypt {
+		t.Errorf("expected err=errDecrypt; got %v", err)
+	}
+	p = append(append([]byte{}, buf.Bytes()...), buf.Bytes()[n[1]:n[2]]...)
+	if r, err = Decrypt(bytes.NewReader(p), "secret"); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if _, err = ioutil.ReadAll(r); err != errDecrypt {
+		t.Errorf("expected err=errDecrypt; got %v", err)
+	}
+}
+
+func TestWriteLogKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakerpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("FAKERPC_KEY", os.Getenv("FAKERPC_KEY"))
+	defer os.Setenv("FAKERPC_KEY_FILE", os.Getenv("FAKERPC_KEY_FILE"))
+	os.Setenv("FAKERPC_KEY", "")
+	os.Setenv("FAKERPC_KEY_FILE", "")
+	file := filepath.Join(dir, "log.gzob")
+	if err = WriteLogKey(file, log, "secret"); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if _, err = ReadLog(file); err != ErrNoKey {
+		t.Errorf("expected err=ErrNoKey; got %v", err)
+	}
+	keyfile := filepath.Join(dir, "key")
+	if err = ioutil.WriteFile(keyfile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("FAKERPC_KEY_FILE", keyfile)
+	l, err := ReadLog(file)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if len(l.T) != len(log.T) {
+		t.Errorf("expected len(l.T)=%d; got %d", len(log.T), len(l.T))
+	}
+	os.Setenv("FAKERPC_KEY", "guess")
+	if _, err = ReadLog(file); err != errDecrypt {
+		t.Errorf("expected err=errDecrypt; got %v", err)
+	}
+	// A log, which is still being recorded, is read as a truncated one.
+	os.Setenv("FAKERPC_KEY", "secret")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ew, err := Encrypt(f, "secret")
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	lw := NewLogWriter(ew)
+	if err = lw.WriteHeader(nil, ""); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if err = lw.Write(log.T); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	l, lf, err := ReadLogFile(file)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if !lf.Truncated || len(l.T) != len(log.T) {
+		t.Errorf("expected truncated log with %d transmissions; got %v, %d", len(log.T), lf.Truncated, len(l.T))
+	}
+	if err = lw.Close(); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if _, lf, err = ReadLogFile(file); err != nil || lf.Truncated {
+		t.Errorf("expected complete log; got %v, %v", lf, err)
+	}
+}
+
+func TestLogFileEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakerpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("FAKERPC_KEY", os.Getenv("FAKERPC_KEY"))
+	os.Setenv("FAKERPC_KEY", "secret")
+	// An encrypted log of version 3.
+	var buf bytes.Buffer
+	buf.Write(logMagic)
+	buf.Write([]byte{0, 3})
+	var w []transmissionV3
+	for _, tr := range log.T {
+		w = append(w, transmissionV3{Src: tr.Src.(*net.TCPAddr), Dst: tr.Dst.(*net.TCPAddr), Raw: tr.Raw})
+	}
+	if err = encodeMember(&buf, &headerV2{Filter: "port 80"}); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if err = encodeMember(&buf, &chunkV3{T: w}); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	var enc bytes.Buffer
+	ew, err := Encrypt(&enc, "secret")
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if _, err = ew.Write(buf.Bytes()); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if err = ew.Close(); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	file := filepath.Join(dir, "log.gzob")
+	if err = ioutil.WriteFile(file, enc.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, lf, err := ReadLogFile(file)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if lf.Version != 3 || !lf.Encrypted {
+		t.Fatalf("expected an encrypted log of version 3; got %+v", lf)
+	}
+	if err = lf.WriteLog(file, l); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := IsEncrypted(bufio.NewReader(f))
+	f.Close()
+	if !encrypted {
+		t.Fatal("expected the upgraded log to stay encrypted")
+	}
+	ul, ulf, err := ReadLogFile(file)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if ulf.Version != LogVersion || len(ul.T) != len(log.T) || ul.Filter != "port 80" {
+		t.Errorf("expected %d transmissions of version %d; got %d, %+v", len(log.T), LogVersion, len(ul.T), ulf)
+	}
+	os.Setenv("FAKERPC_KEY", "")
+	if err = lf.WriteLog(file, l); err != ErrNoKey {
+		t.Errorf("expected err=ErrNoKey; got %v", err)
+	}
+}
@@ -0,0 +1,306 @@
+package fakerpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A ChangeKind tells how a call differs between two logs.
+type ChangeKind int
+
+// Kinds of changes reported by a Differ.
+const (
+	Added    ChangeKind = iota // the call was recorded only in the new log
+	Removed                    // the call was recorded only in the old log
+	Modified                   // the call was recorded in both logs, with differences
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "+"
+	case Removed:
+		return "-"
+	case Modified:
+		return "~"
+	}
+	return "ChangeKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// A Change describes a single call, which differs between two logs.
+type Change struct {
+	Kind   ChangeKind // kind of the change
+	Method string     // method of the request
+	URI    string     // URI of the request
+	Diffs  []string   // differences of a Modified call, e.g. "status: 200 OK -> 404 Not Found"
+}
+
+func (c Change) String() string {
+	s := fmt.Sprintf("%s %s %s", c.Kind, c.Method, c.URI)
+	for _, d := range c.Diffs {
+		s += "\n    " + d
+	}
+	return s
+}
+
+// A Differ compares calls recorded in two logs.
+type Differ struct {
+	// Match function, when non-nil, is used to pair calls of the logs instead
+	// of MatchGRPC. Calls left unpaired are then paired by a method and URI in
+	// order they were recorded, so a call with a changed request body is
+	// reported as modified instead of removed and added.
+	Match Matcher
+	// Ignore is a list of names of response headers, which are not compared.
+	// The Content-Length header is never compared, as bodies are.
+	Ignore []string
+	// IgnoreFields is a list of keys of JSON objects, which are not compared
+	// at any depth of the bodies, like ids or dates.
+	IgnoreFields []string
+}
+
+// Diff compares calls recorded in the x and y logs with a zero Differ.
+func Diff(x, y *Log) ([]Change, error) {
+	return (&Differ{}).Diff(x, y)
+}
+
+// Diff gives calls, which were added, removed or modified in the y log when
+// compared to the x log. Bodies, which are JSON documents, are compared
+// structurally, so neither key order nor formatting is reported.
+func (d *Differ) Diff(x, y *Log) ([]Change, error) {
+	cx, err := calls(x)
+	if err != nil {
+		return nil, err
+	}
+	cy, err := calls(y)
+	if err != nil {
+		return nil, err
+	}
+	match := d.Match
+	if match == nil {
+		match = MatchGRPC
+	}
+	pair, used := make([]int, len(cy)), make([]bool, len(cx))
+	for j := range cy {
+		pair[j] = -1
+		for i := range cx {
+			if !used[i] && match(cy[j].Req, cy[j].ReqBody, &cx[i]) {
+				pair[j], used[i] = i, true
+				break
+			}
+		}
+	}
+	for j := range cy {
+		if pair[j] != -1 {
+			continue
+		}
+		for i := range cx {
+			if !used[i] && cy[j].Req.Method == cx[i].Req.Method &&
+				cy[j].Req.URL.RequestURI() == cx[i].Req.URL.RequestURI() {
+				pair[j], used[i] = i, true
+				break
+			}
+		}
+	}
+	var changes []Change
+	for i := range cx {
+		if !used[i] {
+			changes = append(changes, newChange(Removed, &cx[i], nil))
+		}
+	}
+	for j := range cy {
+		if pair[j] == -1 {
+			changes = append(changes, newChange(Added, &cy[j], nil))
+		} else if diffs := d.diffCall(&cx[pair[j]], &cy[j]); len(diffs) != 0 {
+			changes = append(changes, newChange(Modified, &cy[j], diffs))
+		}
+	}
+	return changes, nil
+}
+
+// calls gives all the calls recorded in the l, in order.
+func calls(l *Log) ([]Connection, error) {
+	if l == nil || len(l.T) == 0 {
+		return nil, nil
+	}
+	conns, err := NewConnections(l)
+	if err != nil {
+		return nil, err
+	}
+	var c []Connection
+	for _, conn := range conns {
+		c = append(c, conn...)
+	}
+	return c, nil
+}
+
+func newChange(kind ChangeKind, c *Connection, diffs []string) Change {
+	return Change{Kind: kind, Method: c.Req.Method, URI: c.Req.URL.RequestURI(), Diffs: diffs}
+}
+
+func (d *Differ) diffCall(x, y *Connection) (diffs []string) {
+	diffs = d.diffBody("request body", x.Body(), y.Body())
+	switch {
+	case x.Res == nil && y.Res == nil:
+		return diffs
+	case x.Res == nil:
+		return append(diffs, "response: (none) -> recorded")
+	case y.Res == nil:
+		return append(diffs, "response: recorded -> (none)")
+	}
+	rx, bx, errx := x.Response()
+	ry, by, erry := y.Response()
+	if errx != nil || erry != nil {
+		if !bytes.Equal(x.Res, y.Res) {
+			diffs = append(diffs, fmt.Sprintf("response: %d bytes -> %d bytes", len(x.Res), len(y.Res)))
+		}
+		return diffs
+	}
+	if rx.Status != ry.Status {
+		diffs = append(diffs, fmt.Sprintf("status: %s -> %s", rx.Status, ry.Status))
+	}
+	diffs = append(diffs, d.diffHeader(rx.Header, ry.Header)...)
+	return append(diffs, d.diffBody("body", bx, by)...)
+}
+
+func (d *Differ) diffHeader(x, y http.Header) (diffs []string) {
+	var keys []string
+	for k := range x {
+		keys = append(keys, k)
+	}
+	for k := range y {
+		if _, ok := x[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if k == "Content-Length" || d.ignored(k) {
+			continue
+		}
+		vx, vy := headerValue(x, k), headerValue(y, k)
+		if vx != vy {
+			diffs = append(diffs, fmt.Sprintf("header %s: %s -> %s", k, vx, vy))
+		}
+	}
+	return diffs
+}
+
+func (d *Differ) ignored(key string) bool {
+	for _, k := range d.Ignore {
+		if http.CanonicalHeaderKey(k) == key {
+			return true
+		}
+	}
+	return false
+}
+
+func headerValue(h http.Header, key string) string {
+	v, ok := h[key]
+	if !ok {
+		return "(none)"
+	}
+	return strconv.Quote(strings.Join(v, ", "))
+}
+
+// maxQuote is the maximum length of a non-JSON body, which is reported
+// with its content.
+const maxQuote = 64
+
+// diffBody compares the bodies, structurally if both are JSON documents.
+func (d *Differ) diffBody(name string, x, y []byte) []string {
+	if bytes.Equal(x, y) {
+		return nil
+	}
+	if vx, err := decodeJSON(x); err == nil {
+		if vy, err := decodeJSON(y); err == nil {
+			var diffs []string
+			d.diffJSON(name, vx, vy, &diffs)
+			return diffs
+		}
+	}
+	if len(x) <= maxQuote && len(y) <= maxQuote && utf8.Valid(x) && utf8.Valid(y) {
+		return []string{fmt.Sprintf("%s: %q -> %q", name, x, y)}
+	}
+	return []string{fmt.Sprintf("%s: %d bytes -> %d bytes", name, len(x), len(y))}
+}
+
+func decodeJSON(p []byte) (v interface{}, err error) {
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+	if err = dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err = dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("fakerpc: trailing data after JSON document")
+	}
+	return v, nil
+}
+
+// diffJSON appends differences between JSON values x and y found under
+// the path to the diffs.
+func (d *Differ) diffJSON(path string, x, y interface{}, diffs *[]string) {
+	switch vx := x.(type) {
+	case map[string]interface{}:
+		if vy, ok := y.(map[string]interface{}); ok {
+			var keys []string
+			for k := range vx {
+				keys = append(keys, k)
+			}
+			for k := range vy {
+				if _, ok := vx[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				if hasString(d.IgnoreFields, k) {
+					continue
+				}
+				ex, okx := vx[k]
+				ey, oky := vy[k]
+				switch p := path + "." + k; {
+				case !okx:
+					*diffs = append(*diffs, fmt.Sprintf("%s: added %s", p, jsonString(ey)))
+				case !oky:
+					*diffs = append(*diffs, fmt.Sprintf("%s: removed %s", p, jsonString(ex)))
+				default:
+					d.diffJSON(p, ex, ey, diffs)
+				}
+			}
+			return
+		}
+	case []interface{}:
+		if vy, ok := y.([]interface{}); ok {
+			for i := 0; i < len(vx) || i < len(vy); i++ {
+				switch p := fmt.Sprintf("%s[%d]", path, i); {
+				case i >= len(vx):
+					*diffs = append(*diffs, fmt.Sprintf("%s: added %s", p, jsonString(vy[i])))
+				case i >= len(vy):
+					*diffs = append(*diffs, fmt.Sprintf("%s: removed %s", p, jsonString(vx[i])))
+				default:
+					d.diffJSON(p, vx[i], vy[i], diffs)
+				}
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(x, y) {
+		*diffs = append(*diffs, fmt.Sprintf("%s: %s -> %s", path, jsonString(x), jsonString(y)))
+	}
+}
+
+func jsonString(v interface{}) string {
+	p, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(p)
+}
@@ -0,0 +1,81 @@
+package fakerpc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func calllog(raw ...string) *Log {
+	l := NewLog()
+	for i := 0; i+1 < len(raw); i += 2 {
+		l.T = append(l.T, Transmission{Src: &cli[0], Dst: srv, Raw: []byte(raw[i])},
+			Transmission{Src: srv, Dst: &cli[0], Raw: []byte(raw[i+1])})
+	}
+	return l
+}
+
+func TestDiff(t *testing.T) {
+	x := calllog(
+		"GET /a HTTP/1.1\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nDate: Mon\r\nContent-Length: 25\r\n\r\n"+
+			`{"n": 1, "s": ["x", "y"]}`,
+		"POST /b HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc",
+		"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nOK",
+		"GET /c HTTP/1.1\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n",
+	)
+	y := calllog(
+		"GET /a HTTP/1.1\r\n\r\n",
+		"HTTP/1.1 404 Not Found\r\nContent-Type: text/json\r\nDate: Tue\r\nContent-Length: 26\r\n\r\n"+
+			`{"s":["x"],"n":2,"m":null}`,
+		"POST /b HTTP/1.1\r\nContent-Length: 3\r\n\r\nabd",
+		"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nOK",
+		"GET /d HTTP/1.1\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n",
+	)
+	exp := []Change{
+		{Kind: Removed, Method: "GET", URI: "/c"},
+		{Kind: Modified, Method: "GET", URI: "/a", Diffs: []string{
+			"status: 200 OK -> 404 Not Found",
+			`header Content-Type: "application/json" -> "text/json"`,
+			"body.m: added null",
+			"body.n: 1 -> 2",
+			`body.s[1]: removed "y"`,
+		}},
+		{Kind: Modified, Method: "POST", URI: "/b", Diffs: []string{`request body: "abc" -> "abd"`}},
+		{Kind: Added, Method: "GET", URI: "/d"},
+	}
+	d := &Differ{Ignore: []string{"date"}}
+	changes, err := d.Diff(x, y)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if !reflect.DeepEqual(changes, exp) {
+		t.Errorf("expected changes=%v; got %v", exp, changes)
+	}
+	if changes, err = Diff(x, x); err != nil || len(changes) != 0 {
+		t.Errorf("expected changes=[], err=nil; got %v, %v", changes, err)
+	}
+	if changes, err = Diff(nil, x); err != nil || len(changes) != 3 || changes[0].Kind != Added {
+		t.Errorf("expected 3 added calls, err=nil; got %v, %v", changes, err)
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	cases := [...]struct {
+		p  string
+		ok bool
+	}{
+		{`{"a":1}`, true},
+		{" [1, 2]\n", true},
+		{`{"a":1}}`, false},
+		{`[1]]`, false},
+		{`{"a":1} {"b":2}`, false},
+		{`{"a":1} x`, false},
+	}
+	for i, cas := range cases {
+		if _, err := decodeJSON([]byte(cas.p)); (err == nil) != cas.ok {
+			t.Errorf("expected ok=%v; got err=%v (i=%d)", cas.ok, err, i)
+		}
+	}
+}
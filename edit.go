@@ -0,0 +1,206 @@
+package fakerpc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+var errNoHeader = errors.New("fakerpc: message has no header")
+
+// writeAtomic writes a file with the write function, so that the file is
+// either replaced as a whole or left untouched on error. An existing file keeps
+// its mode; a new one is created with the 0644 mode.
+func writeAtomic(file string, write func(w io.Writer) error) error {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(file); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	f, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if err = write(f); err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Chmod(tmp, mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// call gives the j-th exchange of the i-th connection of the l, as ordered
+// by Conns.
+func (l *Log) call(i, j int) (exchange, error) {
+	var (
+		index = make(map[string]int)
+		n     []int
+	)
+	for _, e := range exchanges(l) {
+		addr := l.T[e.req].Src.String()
+		k, ok := index[addr]
+		if !ok {
+			k = len(n)
+			index[addr] = k
+			n = append(n, 0)
+		}
+		if k == i && n[k] == j {
+			return e, nil
+		}
+		n[k]++
+	}
+	if i < 0 || i >= len(n) {
+		return exchange{}, fmt.Errorf("fakerpc: no connection %d; log has %d", i, len(n))
+	}
+	return exchange{}, fmt.Errorf("fakerpc: no call %d in connection %d; it has %d", j, i, n[i])
+}
+
+// Call gives indices of the transmissions holding a request and a response of
+// the j-th call of the i-th connection of the l, as ordered by Conns. The res
+// is -1 if no response was recorded for the request.
+func (l *Log) Call(i, j int) (req, res int, err error) {
+	e, err := l.call(i, j)
+	if err != nil {
+		return -1, -1, err
+	}
+	return e.req, e.res, nil
+}
+
+// RemoveConn gives a log without transmissions of the i-th connection of the l,
+// as ordered by Conns.
+func (l *Log) RemoveConn(i int) (*Log, error) {
+	e, err := l.call(i, 0)
+	if err != nil {
+		return nil, err
+	}
+	addr := l.T[e.req].Src.String()
+	return l.pick(func(_ int, e exchange) bool {
+		return l.T[e.req].Src.String() != addr
+	}), nil
+}
+
+// RemoveCall gives a log without the j-th call of the i-th connection of the l,
+// as ordered by Conns, along with its response and tunnel.
+func (l *Log) RemoveCall(i, j int) (*Log, error) {
+	rm, err := l.call(i, j)
+	if err != nil {
+		return nil, err
+	}
+	return l.pick(func(_ int, e exchange) bool {
+		return e.req != rm.req
+	}), nil
+}
+
+// SetHeader gives a copy of the raw HTTP message with all the values of
+// the header key replaced with the value. An empty value removes the header.
+func SetHeader(raw []byte, key, value string) ([]byte, error) {
+	header, body := SplitHeaderBody(raw)
+	if header == nil {
+		return nil, errNoHeader
+	}
+	// Lines are split on LF and keep their own endings, as ngrep outputs may
+	// mix them with CRLF ones.
+	lines := bytes.SplitAfter(header, []byte("\n"))
+	lines = lines[:len(lines)-1]
+	first, blank, fields := lines[0], lines[len(lines)-1], lines[1:len(lines)-1]
+	// A new field ends like the line it follows.
+	last, eol := first, []byte("\n")
+	if len(fields) != 0 {
+		last = fields[len(fields)-1]
+	}
+	if bytes.HasSuffix(last, []byte("\r\n")) {
+		eol = []byte("\r\n")
+	}
+	var buf bytes.Buffer
+	buf.Write(first)
+	key = http.CanonicalHeaderKey(key)
+	for _, line := range fields {
+		if i := bytes.IndexByte(line, ':'); i != -1 &&
+			http.CanonicalHeaderKey(string(bytes.TrimSpace(line[:i]))) == key {
+			continue
+		}
+		buf.Write(line)
+	}
+	if value != "" {
+		buf.WriteString(key + ": " + value)
+		buf.Write(eol)
+	}
+	buf.Write(blank)
+	buf.Write(body)
+	return buf.Bytes(), nil
+}
+
+// SetBody gives a copy of the raw HTTP message with the body replaced.
+// The body is stored uncompressed with the Content-Length header, so any
+// Transfer-Encoding and Content-Encoding headers are removed.
+func SetBody(raw, body []byte) ([]byte, error) {
+	p, err := SetHeader(raw, "Transfer-Encoding", "")
+	if err != nil {
+		return nil, err
+	}
+	if p, err = SetHeader(p, "Content-Encoding", ""); err != nil {
+		return nil, err
+	}
+	if p, err = SetHeader(p, "Content-Length", strconv.Itoa(len(body))); err != nil {
+		return nil, err
+	}
+	header, _ := SplitHeaderBody(p)
+	return append(header, body...), nil
+}
+
+// Redact replaces, in place, values of the headers of HTTP requests and
+// responses of the t with the RedactedValue. Transmissions of tunnels are left
+// intact.
+func Redact(t []Transmission, headers []string) {
+	if len(headers) == 0 {
+		return
+	}
+	for _, e := range exchanges(&Log{T: t}) {
+		t[e.req].Raw = redact(t[e.req].Raw, headers)
+		if e.res != -1 {
+			t[e.res].Raw = redact(t[e.res].Raw, headers)
+		}
+	}
+}
+
+// redact gives a copy of the raw HTTP message with values of the headers
+// replaced with the RedactedValue, keeping their order.
+func redact(raw []byte, headers []string) []byte {
+	header, body := SplitHeaderBody(raw)
+	if header == nil {
+		return raw
+	}
+	var buf bytes.Buffer
+	for i, line := range bytes.SplitAfter(header, []byte("\n")) {
+		if j := bytes.IndexByte(line, ':'); i != 0 && j != -1 {
+			key := http.CanonicalHeaderKey(string(bytes.TrimSpace(line[:j])))
+			for _, h := range headers {
+				if http.CanonicalHeaderKey(h) == key {
+					buf.Write(line[:j+1])
+					buf.WriteString(" " + RedactedValue)
+					line = line[len(bytes.TrimRight(line, "\r\n")):]
+					break
+				}
+			}
+		}
+		buf.Write(line)
+	}
+	buf.Write(body)
+	return buf.Bytes()
+}
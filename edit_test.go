@@ -0,0 +1,169 @@
+package fakerpc
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogRemove(t *testing.T) {
+	req, res, err := log.Call(0, 1)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if req != 2 || res != 3 {
+		t.Errorf("expected req=2, res=3; got %d, %d", req, res)
+	}
+	l, err := log.RemoveCall(0, 1)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if len(l.T) != len(log.T)-2 || string(l.T[2].Raw) != string(log.T[4].Raw) {
+		t.Errorf("expected transmissions 2 and 3 to be removed; got %v", l.T)
+	}
+	if l, err = log.RemoveConn(0); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if conns := l.Conns(); len(conns) != 2 || !addrequal(conns[0].T[0].Src, &cli[1]) {
+		t.Errorf("expected the first connection to be removed; got %v", l.T)
+	}
+	for _, c := range [][2]int{{3, 0}, {-1, 0}, {1, 1}, {0, -1}} {
+		if _, err = log.RemoveCall(c[0], c[1]); err == nil {
+			t.Errorf("expected err!=nil (i=%d, j=%d)", c[0], c[1])
+		}
+	}
+}
+
+func TestSetHeaderBody(t *testing.T) {
+	raw := []byte("HTTP/1.1 200 OK\r\nDate: Mon\r\nContent-Encoding: gzip\r\n" +
+		"Transfer-Encoding: chunked\r\nX-Id: 1\r\nx-id: 2\r\n\r\n2\r\nxx\r\n0\r\n\r\n")
+	cases := [...]struct {
+		key, value string
+		exp        string
+	}{{
+		"x-id", "3",
+		"HTTP/1.1 200 OK\r\nDate: Mon\r\nContent-Encoding: gzip\r\nTransfer-Encoding: chunked\r\n" +
+			"X-Id: 3\r\n\r\n2\r\nxx\r\n0\r\n\r\n",
+	}, {
+		"Date", "",
+		"HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nTransfer-Encoding: chunked\r\nX-Id: 1\r\n" +
+			"x-id: 2\r\n\r\n2\r\nxx\r\n0\r\n\r\n",
+	}}
+	for i, cas := range cases {
+		p, err := SetHeader(raw, cas.key, cas.value)
+		if err != nil {
+			t.Errorf("expected err=nil; got %q (i=%d)", err, i)
+			continue
+		}
+		if string(p) != cas.exp {
+			t.Errorf("expected p=%q; got %q (i=%d)", cas.exp, p, i)
+		}
+	}
+	p, err := SetBody(raw, []byte("plain"))
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	exp := "HTTP/1.1 200 OK\r\nDate: Mon\r\nX-Id: 1\r\nx-id: 2\r\nContent-Length: 5\r\n\r\nplain"
+	if string(p) != exp {
+		t.Errorf("expected p=%q; got %q", exp, p)
+	}
+	if p, err = SetHeader([]byte("GET / HTTP/1.1\n\n"), "Host", "x"); err != nil || string(p) != "GET / HTTP/1.1\nHost: x\n\n" {
+		t.Errorf("expected p=%q, err=nil; got %q, %v", "GET / HTTP/1.1\nHost: x\n\n", p, err)
+	}
+	// Headers of ngrep outputs mix LF and CRLF line endings.
+	mixed := []byte("HTTP/1.1 200 OK\nContent-Length: 4\r\n\r\nHAAI")
+	if p, err = SetBody(mixed, []byte("plain")); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if exp := "HTTP/1.1 200 OK\nContent-Length: 5\r\n\r\nplain"; string(p) != exp {
+		t.Errorf("expected p=%q; got %q", exp, p)
+	}
+	if _, err = http.ReadResponse(bufio.NewReader(bytes.NewReader(p)), nil); err != nil {
+		t.Errorf("expected err=nil; got %q", err)
+	}
+	if _, err = SetHeader([]byte("GET / HTTP/1.1\r\n"), "Host", "x"); err != errNoHeader {
+		t.Errorf("expected err=errNoHeader; got %v", err)
+	}
+}
+
+func TestWriteLogAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakerpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "log.gzob")
+	if err = ioutil.WriteFile(file, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = WriteLog(file, log); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 || fis[0].Name() != "log.gzob" || fis[0].Mode().Perm() != 0600 {
+		t.Errorf("expected only log.gzob with its 0600 mode kept; got %v", fis)
+	}
+	if _, err = ReadLog(file); err != nil {
+		t.Errorf("expected err=nil; got %q", err)
+	}
+	if err = WriteLog(filepath.Join(dir, "missing", "log.gzob"), log); err == nil {
+		t.Error("expected err!=nil for a missing directory")
+	}
+	if err = WriteLog(filepath.Join(dir, "new.gzob"), log); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "new.gzob")); err != nil || fi.Mode().Perm() != 0644 {
+		t.Errorf("expected new.gzob with 0644 mode; got %v, %v", fi, err)
+	}
+	file = filepath.Join(dir, "log.json")
+	var buf bytes.Buffer
+	if err = JSONMarshal(&buf, log); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if err = ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, lf, err := ReadLogFile(file)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if l, err = l.RemoveConn(0); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if err = lf.WriteLog(file, l); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if l, lf, err = ReadLogFile(file); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if lf.Codec.Name != "json" {
+		t.Errorf("expected codec=json; got %q", lf.Codec.Name)
+	}
+	if len(l.T) >= len(log.T) {
+		t.Errorf("expected len(l.T)<%d; got %d", len(log.T), len(l.T))
+	}
+}
+
+func TestRedact(t *testing.T) {
+	l := calllog(
+		"GET / HTTP/1.1\r\nHost: api.local\r\nAuthorization: Bearer x\r\ncookie: a=1\r\n\r\n",
+		"HTTP/1.1 200 OK\nSet-Cookie: a=2\nContent-Length: 2\n\nOK",
+	)
+	Redact(l.T, []string{"Authorization", "Cookie", "set-cookie"})
+	exp := []string{
+		"GET / HTTP/1.1\r\nHost: api.local\r\nAuthorization: REDACTED\r\ncookie: REDACTED\r\n\r\n",
+		"HTTP/1.1 200 OK\nSet-Cookie: REDACTED\nContent-Length: 2\n\nOK",
+	}
+	for i := range l.T {
+		if string(l.T[i].Raw) != exp[i] {
+			t.Errorf("expected raw=%q; got %q (i=%d)", exp[i], l.T[i].Raw, i)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package fakerpc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Redacted is a list of headers, which values are replaced with a placeholder
+// by an Exporter with no Redact list set.
+var Redacted = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+}
+
+// RedactedValue replaces values of redacted headers.
+const RedactedValue = "REDACTED"
+
+// An Exporter turns recorded requests into code reproducing them, either
+// a curl command line or a Go snippet building a *http.Request.
+type Exporter struct {
+	// Target is a base URL the requests are sent to, like http://staging.local.
+	// If empty, the requests are sent to the recorded Host over HTTP.
+	Target string
+
+	// Redact is a list of headers, which values are replaced with
+	// the RedactedValue. If nil, the Redacted list is used; an empty, non-nil
+	// list disables redaction.
+	Redact []string
+}
+
+// Curl gives a curl command line for the request of the c using the default
+// Exporter.
+func Curl(c *Connection) string {
+	return (&Exporter{}).Curl(c)
+}
+
+// GoRequest gives a Go snippet building the request of the c using the default
+// Exporter.
+func GoRequest(c *Connection) string {
+	return (&Exporter{}).GoRequest(c)
+}
+
+// Curl gives a curl command line sending the request of the c. A body, which
+// is not valid UTF-8 or contains NUL bytes, is piped to curl base64-encoded.
+// Tunnelled requests can't be reproduced, so a comment is given instead.
+func (e *Exporter) Curl(c *Connection) string {
+	if tunnelled(c) {
+		return "# " + c.Req.Method + " " + c.Req.RequestURI + " is not exported: tunnelled connections can't be reproduced\n"
+	}
+	var buf bytes.Buffer
+	body := c.ReqBody
+	if len(body) != 0 && !argsafe(body) {
+		fmt.Fprintf(&buf, "echo %s | base64 -d | ", base64.StdEncoding.EncodeToString(body))
+	}
+	buf.WriteString("curl")
+	if c.Req.Method != "GET" || len(body) != 0 {
+		buf.WriteString(" -X " + c.Req.Method)
+	}
+	buf.WriteString(" " + shquote(e.url(c.Req)))
+	for _, kv := range e.header(c.Req) {
+		buf.WriteString(" \\\n  -H " + shquote(kv[0]+": "+kv[1]))
+	}
+	switch {
+	case len(body) == 0:
+	case argsafe(body):
+		buf.WriteString(" \\\n  --data-binary " + shquote(string(body)))
+	default:
+		buf.WriteString(" \\\n  --data-binary @-")
+	}
+	buf.WriteByte('\n')
+	return buf.String()
+}
+
+// GoRequest gives a Go snippet building a *http.Request for the request of
+// the c. Tunnelled requests can't be reproduced, so a comment is given instead.
+func (e *Exporter) GoRequest(c *Connection) string {
+	if tunnelled(c) {
+		return "// " + c.Req.Method + " " + c.Req.RequestURI + " is not exported: tunnelled connections can't be reproduced\n"
+	}
+	var buf bytes.Buffer
+	body := "nil"
+	if len(c.ReqBody) != 0 {
+		body = fmt.Sprintf("strings.NewReader(%q)", c.ReqBody)
+	}
+	fmt.Fprintf(&buf, "req, err := http.NewRequest(%q, %q, %s)\n", c.Req.Method, e.url(c.Req), body)
+	buf.WriteString("if err != nil {\n\t// handle the error\n}\n")
+	for _, kv := range e.header(c.Req) {
+		fmt.Fprintf(&buf, "req.Header.Add(%q, %q)\n", kv[0], kv[1])
+	}
+	return buf.String()
+}
+
+// url gives an URL of the req, relative to the Target if it's set.
+func (e *Exporter) url(req *http.Request) string {
+	if e.Target != "" {
+		return strings.TrimRight(e.Target, "/") + req.URL.RequestURI()
+	}
+	return "http://" + req.Host + req.URL.RequestURI()
+}
+
+// header gives sorted headers of the req with sensitive values redacted.
+// Headers describing the connection or the body encoding, which the client
+// sets by itself, are skipped.
+func (e *Exporter) header(req *http.Request) (kv [][2]string) {
+	redact := e.Redact
+	if redact == nil {
+		redact = Redacted
+	}
+	keys := make([]string, 0, len(req.Header))
+	for k := range req.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		switch k {
+		case "Host", "Content-Length", "Connection", "Transfer-Encoding":
+			continue
+		}
+		for _, v := range req.Header[k] {
+			for _, r := range redact {
+				if http.CanonicalHeaderKey(r) == k {
+					v = RedactedValue
+					break
+				}
+			}
+			kv = append(kv, [2]string{k, v})
+		}
+	}
+	return kv
+}
+
+// tunnelled reports whether the c is a CONNECT request or an upgrade.
+func tunnelled(c *Connection) bool {
+	return c.Tunnel != nil || c.Req.Method == "CONNECT"
+}
+
+// argsafe reports whether the p can be passed as a shell argument.
+func argsafe(p []byte) bool {
+	return utf8.Valid(p) && bytes.IndexByte(p, 0) == -1
+}
+
+// shquote quotes the s for a POSIX shell.
+func shquote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
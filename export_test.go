@@ -0,0 +1,57 @@
+package fakerpc
+
+import "testing"
+
+func TestExporter(t *testing.T) {
+	conns, err := NewConnections(calllog(
+		"GET /a?q=1 HTTP/1.1\r\nHost: api.local\r\nAuthorization: Bearer x\r\nAccept: */*\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n",
+		"POST /b HTTP/1.1\r\nHost: api.local\r\nContent-Length: 9\r\nX-Name: it's\r\n\r\ndon't\x00\x01\x02\x03",
+		"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n",
+		"CONNECT api.local:443 HTTP/1.1\r\nHost: api.local:443\r\n\r\n",
+		"HTTP/1.1 200 OK\r\n\r\n",
+	))
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	cases := [...]struct {
+		e    *Exporter
+		i    int
+		curl string
+		req  string
+	}{{
+		&Exporter{}, 0,
+		"curl 'http://api.local/a?q=1' \\\n  -H 'Accept: */*' \\\n  -H 'Authorization: REDACTED'\n",
+		"req, err := http.NewRequest(\"GET\", \"http://api.local/a?q=1\", nil)\nif err != nil {\n\t// handle the error\n}\n" +
+			"req.Header.Add(\"Accept\", \"*/*\")\nreq.Header.Add(\"Authorization\", \"REDACTED\")\n",
+	}, {
+		&Exporter{Target: "https://staging.local/", Redact: []string{}}, 0,
+		"curl 'https://staging.local/a?q=1' \\\n  -H 'Accept: */*' \\\n  -H 'Authorization: Bearer x'\n",
+		"req, err := http.NewRequest(\"GET\", \"https://staging.local/a?q=1\", nil)\nif err != nil {\n\t// handle the error\n}\n" +
+			"req.Header.Add(\"Accept\", \"*/*\")\nreq.Header.Add(\"Authorization\", \"Bearer x\")\n",
+	}, {
+		&Exporter{Redact: []string{"x-name"}}, 1,
+		"echo ZG9uJ3QAAQID | base64 -d | curl -X POST 'http://api.local/b' \\\n  -H 'X-Name: REDACTED' \\\n  --data-binary @-\n",
+		"req, err := http.NewRequest(\"POST\", \"http://api.local/b\", strings.NewReader(\"don't\\x00\\x01\\x02\\x03\"))\n" +
+			"if err != nil {\n\t// handle the error\n}\nreq.Header.Add(\"X-Name\", \"REDACTED\")\n",
+	}, {
+		&Exporter{}, 2,
+		"# CONNECT api.local:443 is not exported: tunnelled connections can't be reproduced\n",
+		"// CONNECT api.local:443 is not exported: tunnelled connections can't be reproduced\n",
+	}}
+	for i, cas := range cases {
+		c := &conns[0][cas.i]
+		if curl := cas.e.Curl(c); curl != cas.curl {
+			t.Errorf("expected curl=%q; got %q (i=%d)", cas.curl, curl, i)
+		}
+		if req := cas.e.GoRequest(c); req != cas.req {
+			t.Errorf("expected req=%q; got %q (i=%d)", cas.req, req, i)
+		}
+	}
+	exp := "curl -X POST 'http://api.local/b' \\\n  -H 'X-Name: it'\\''s' \\\n  --data-binary 'don'\\''t'\n"
+	c := conns[0][1]
+	c.ReqBody = []byte("don't")
+	if curl := Curl(&c); curl != exp {
+		t.Errorf("expected curl=%q; got %q", exp, curl)
+	}
+}
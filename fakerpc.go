@@ -3,39 +3,55 @@ package fakerpc
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
-	"encoding/gob"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"os"
+	"time"
 )
 
-// ErrAlreadyRunning is returned when calling ListenAndServe on a server which
-// was already started by ListenAndServe.
+// ErrAlreadyRunning is returned when calling ListenAndServe or Serve on a server
+// which was already started by either of them.
 var ErrAlreadyRunning = errors.New("fakerpc: server is already running")
 
 // ErrNotRunning is returned when calling Stop on a server which wasn't started
-// by ListenAndServe or was already stopped by Stop.
+// by ListenAndServe or Serve, or was already stopped by Stop.
 var ErrNotRunning = errors.New("fakerpc: server is not running")
 
-// A Transmission represents a single raw data transmission between two TCP
-// end points.
+// A Transmission represents a single raw data transmission between two end
+// points, either TCP or Unix domain sockets.
+//
+// Src and Dst used to be *net.TCPAddr; code, which needs an IP or a port of
+// a TCP end point, type-asserts them, like t.Src.(*net.TCPAddr).
 type Transmission struct {
-	// Src is a TCP address of the source.
-	Src *net.TCPAddr
-	// Dst is a TCP address of the destination.
-	Dst *net.TCPAddr
+	// Src is an address of the source, a *net.TCPAddr or a *net.UnixAddr.
+	// Unnamed client sockets of Unix domain connections are given addresses
+	// like "@1", numbering the connections.
+	Src net.Addr
+	// Dst is an address of the destination.
+	Dst net.Addr
 	// Raw contains all the recorded bytes sent from Src to Dst until Dst began
-	// replying back to Src.
+	// replying back to Src. For HTTP/2 streams Raw contains either a request
+	// or a response formatted as HTTP/1.x message. After WebSocket upgrade
+	// Raw contains a single frame.
 	Raw []byte
+	// Stream identifies HTTP/2 stream the transmission belongs to; it's 0 for HTTP/1.x
+	// transmissions. Recorded streams are numbered 1, 3, 5... in the order the proxy
+	// received their requests, which is not necessarily the identifiers the client
+	// used on the wire.
+	Stream uint32
+	// Time is the time the first byte of the transmission was recorded at; it's
+	// zero if unknown, e.g. for logs parsed from a ngrep output.
+	Time time.Time
 }
 
 // A Log represents communication session, either captured by a Proxy or parsed
 // from a ngrep output.
 type Log struct {
 	// Network is an address of the networks, in which communication took place.
+	// It's empty for a communication over Unix domain sockets.
 	Networks []*net.IPNet
 	// Filter is an effective pcap filter applied to the recording session.
 	Filter string
@@ -59,48 +75,129 @@ func NewLog() *Log {
 }
 
 // ReadLog gives Log decoded from the given file. It assumes the file contains
-// gzipped, gob-encoded Log struct. If it does not, it treats the file as a ngrep
-// output.
+// a log written by WriteLog or WriteLogStore, in any of the supported format
+// versions. If the file is not recognized as such, it treats it as a JSON
+// document written by JSONMarshal or as a ngrep output. Errors decoding
+// a recognized log are reported as they are; a truncated tail of the log is
+// ignored, which ReadLogFile reports.
 func ReadLog(file string) (*Log, error) {
+	l, _, err := ReadLogFile(file)
+	return l, err
+}
+
+// A LogFile describes a file a log was read from by ReadLogFile.
+type LogFile struct {
+	// Codec is a codec of the format the file is written in.
+	Codec Codec
+	// Version is a version of the log format; it's 0 for a legacy log and for
+	// formats other than gob.
+	Version int
+	// Truncated reports whether the log's tail was truncated, e.g. when
+	// recording process was killed. The tail is not part of the read log.
+	Truncated bool
+	// Encrypted reports whether the log is encrypted.
+	Encrypted bool
+	// Threshold is a size of the smallest body kept out of the log, in
+	// the BodyDir next to the file; it's 0 if the log keeps all its bodies.
+	Threshold int
+}
+
+// ReadLogFile reads the log from the file like ReadLog, describing the file as
+// well.
+func ReadLogFile(file string) (*Log, *LogFile, error) {
 	f, err := os.Open(file)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer f.Close()
-	l := NewLog()
-	r, err := gzip.NewReader(f)
-	if err != nil {
-		f.Seek(0, 0)
-		return l, NgrepUnmarshal(f, l)
+	gob, _ := CodecByName("gob")
+	r := bufio.NewReader(f)
+	encrypted := IsEncrypted(r)
+	l, lr, err := decodeLog(r, StoreFor(file))
+	if err != ErrUnknownFormat {
+		if err != nil {
+			return nil, nil, err
+		}
+		return l, &LogFile{
+			Codec:     gob,
+			Version:   lr.version,
+			Truncated: lr.trunc,
+			Encrypted: encrypted,
+			Threshold: lr.stored,
+		}, nil
 	}
-	defer r.Close()
-	if err = gob.NewDecoder(r).Decode(l); err != nil {
-		f.Seek(0, 0)
-		return l, NgrepUnmarshal(f, l)
+	if _, err = f.Seek(0, 0); err != nil {
+		return nil, nil, err
 	}
-	return l, nil
+	r = bufio.NewReader(f)
+	p, _ := r.Peek(512)
+	c := DetectCodec(p)
+	l = NewLog()
+	if err = c.Unmarshal(r, l); err != nil {
+		return nil, nil, fmt.Errorf("fakerpc: %s is neither a log nor a %s output: %v", file, c.Name, err)
+	}
+	return l, &LogFile{Codec: c}, nil
 }
 
-// WriteLog writes gzipped, gob-encoded Log struct to the file.
-func WriteLog(file string, l *Log) error {
-	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	w, err := gzip.NewWriterLevel(f, gzip.BestCompression)
-	if err != nil {
-		return err
+// WriteLog writes the l to the file in the format of the file described by
+// the lf, storing it the same way, so rewriting a log does not change how it's
+// kept. Gob logs are written in the LogVersion format; an encrypted one is
+// encrypted again with a key given by EnvKey, and one keeping bodies in a store
+// keeps the ones of at least Threshold bytes there.
+func (lf *LogFile) WriteLog(file string, l *Log) error {
+	switch {
+	case lf.Codec.Name != "" && lf.Codec.Name != "gob":
+		return writeAtomic(file, func(w io.Writer) error {
+			return lf.Codec.Marshal(w, l)
+		})
+	case lf.Encrypted:
+		key, err := EnvKey()
+		if err != nil {
+			return err
+		}
+		if key == "" {
+			return ErrNoKey
+		}
+		return WriteLogKey(file, l, key)
+	case lf.Threshold > 0:
+		return WriteLogStore(file, l, lf.Threshold)
 	}
-	defer w.Close()
-	return gob.NewEncoder(w).Encode(l)
+	return WriteLog(file, l)
+}
+
+// WriteLog writes the Log to the file, in the LogVersion format. The file is
+// replaced atomically, so it's left untouched if writing fails.
+func WriteLog(file string, l *Log) error {
+	return writeAtomic(file, func(w io.Writer) error {
+		return EncodeLog(w, l)
+	})
 }
 
 // A Connection represents a single request/reponse communication.
 type Connection struct {
-	Req     *http.Request // a HTTP header of the request
-	ReqBody []byte        // a body of the request
-	Res     []byte        // raw response
+	Req     *http.Request  // a HTTP header of the request
+	ReqBody []byte         // a body of the request
+	Res     []byte         // raw response
+	Tunnel  []Transmission // transmissions following a CONNECT request or an upgrade
+}
+
+// GobCalls gives net/rpc calls decoded from the c's tunnel.
+func (c *Connection) GobCalls() ([]GobCall, error) {
+	return NewGobCalls(c.Tunnel)
+}
+
+// Body gives the request body decompressed according to its Content-Encoding.
+func (c *Connection) Body() []byte {
+	return decodeBody(c.ReqBody, c.Req.Header.Get("Content-Encoding"))
+}
+
+// Response parses the recorded response, giving its body decoded according to
+// the Transfer-Encoding and Content-Encoding.
+func (c *Connection) Response() (*http.Response, []byte, error) {
+	if c.Res == nil {
+		return nil, nil, errNoResponse
+	}
+	return readResponse(c.Res, c.Req)
 }
 
 // Connections represent Log's transmissions grouped per connection.
@@ -109,18 +206,18 @@ type Connections [][]Connection
 // NewConnections gives a Connections for the given log.
 func NewConnections(log *Log) (Connections, error) {
 	if log == nil || len(log.T) == 0 {
-		return nil, errors.New("fakerpc: log is either nil or empty")
+		return nil, errEmptyLog
 	}
 	c, index := make(Connections, 0), make(map[string]int)
-	for i := 0; i < len(log.T); {
-		addr := log.T[i].Src.String()
+	for _, e := range exchanges(log) {
+		addr := log.T[e.req].Src.String()
 		n, ok := index[addr]
 		if !ok {
 			c = append(c, make([]Connection, 0))
 			n = len(c) - 1
 			index[addr] = n
 		}
-		header, body := SplitHeaderBody(log.T[i].Raw)
+		header, body := SplitHeaderBody(log.T[e.req].Raw)
 		req, err := http.ReadRequest(bufio.NewReader(bytes.NewBuffer(header)))
 		if err != nil {
 			return nil, err
@@ -130,29 +227,55 @@ func NewConnections(log *Log) (Connections, error) {
 			req.ContentLength = int64(len(body))
 		}
 		if int64(len(body)) < req.ContentLength {
-			return nil, errors.New("fakerpc: recorded body length is too small")
+			return nil, errShortBody
 		}
 		body = body[:req.ContentLength]
 		if len(body) > 0 {
 			conn.ReqBody = make([]byte, len(body))
 			copy(conn.ReqBody, body)
 		}
-		if i+1 < len(log.T) && tcpaddrequal(log.T[i].Src, log.T[i+1].Dst) {
-			i += 1
-			conn.Res = make([]byte, len(log.T[i].Raw))
-			copy(conn.Res, log.T[i].Raw)
+		if e.res != -1 {
+			conn.Res = make([]byte, len(log.T[e.res].Raw))
+			copy(conn.Res, log.T[e.res].Raw)
+			for _, k := range e.tunnel {
+				conn.Tunnel = append(conn.Tunnel, log.T[k])
+			}
 		}
-		i += 1
 		c[n] = append(c[n], conn)
 	}
 	return c, nil
 }
 
+// response gives an index of the transmission, which is a response for the
+// i-th one, or -1 if there is none. Responses for HTTP/2 streams are looked up
+// by the stream identifier, as streams of a single connection may interleave.
+func response(log *Log, i int, skip map[int]struct{}) int {
+	if log.T[i].Stream == 0 {
+		if i+1 < len(log.T) && addrequal(log.T[i].Src, log.T[i+1].Dst) {
+			return i + 1
+		}
+		return -1
+	}
+	for j := i + 1; j < len(log.T); j++ {
+		if _, ok := skip[j]; ok {
+			continue
+		}
+		if log.T[j].Stream == log.T[i].Stream && addrequal(log.T[i].Src, log.T[j].Dst) {
+			return j
+		}
+	}
+	return -1
+}
+
 // SplitHeaderBody splits raw HTTP request/response into header and body.
 func SplitHeaderBody(p []byte) (header []byte, body []byte) {
-	if n := bytes.Index(p, []byte("\r\n\r\n")); n != -1 {
-		header = p[:n+4]
-		body = p[n+4:]
+	n, k := bytes.Index(p, []byte("\r\n\r\n")), 4
+	if m := bytes.Index(p, []byte("\n\n")); m != -1 && (n == -1 || m < n) {
+		n, k = m, 2
+	}
+	if n != -1 {
+		header = p[:n+k]
+		body = p[n+k:]
 	}
 	return
 }
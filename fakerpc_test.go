@@ -2,9 +2,11 @@ package fakerpc
 
 import (
 	"bytes"
+	"compress/gzip"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"testing"
 )
 
@@ -130,3 +132,32 @@ func TestNewConnectionsErr(t *testing.T) {
 		}
 	}
 }
+
+func TestConnectionResponse(t *testing.T) {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	gz.Write([]byte(`{"ok":true}`))
+	gz.Close()
+	l := calllog(
+		"POST / HTTP/1.1\r\nContent-Encoding: gzip\r\nContent-Length: "+strconv.Itoa(body.Len())+"\r\n\r\n"+body.String(),
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nContent-Encoding: gzip\r\n\r\n"+
+			strconv.FormatInt(int64(body.Len()), 16)+"\r\n"+body.String()+"\r\n0\r\n\r\n",
+	)
+	c, err := NewConnections(l)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if p := c[0][0].Body(); string(p) != `{"ok":true}` {
+		t.Errorf("expected Body()=%q; got %q", `{"ok":true}`, p)
+	}
+	res, p, err := c[0][0].Response()
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if res.StatusCode != 200 || string(p) != `{"ok":true}` {
+		t.Errorf("expected StatusCode=200, body=%q; got %d, %q", `{"ok":true}`, res.StatusCode, p)
+	}
+	if _, _, err = (&Connection{Req: c[0][0].Req}).Response(); err != errNoResponse {
+		t.Errorf("expected err=errNoResponse; got %v", err)
+	}
+}
@@ -1,16 +1,22 @@
 package fakerpc
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
-	"strings"
+	"strconv"
 	"testing"
+	"time"
 )
 
 var re = regexp.MustCompile(`.*\.(Test.*)`)
 
+// teardownTimeout bounds how long a teardown waits for exchanges in progress
+// before closing their connections.
+var teardownTimeout = 10 * time.Second
+
 // Fixture provides a fake server for mocking HTTP-based RPC services. A single
 // fixture has TestXxx-function scope, which makes it sutiable for parallel test
 // execution. Typical usage of the Fixture function is to setup the mock at the
@@ -43,6 +49,15 @@ var re = regexp.MustCompile(`.*\.(Test.*)`)
 // end point. Example:
 //
 //   $ FAKERPC_RECORD="http://rpc.int.mycompany.com:8079" go test ./...
+//
+// Setting FAKERPC_DEDUP environment variable to a number of bytes makes bodies
+// of at least that size being recorded once, in the ./testdata/bodies directory
+// shared by all the record-log files (see WriteLogStore).
+//
+// Setting FAKERPC_KEY environment variable to a passphrase, or FAKERPC_KEY_FILE
+// to a file containing it, makes the record-log files being encrypted (see
+// WriteLogKey) and decrypted when replied. Bodies of encrypted logs are never
+// deduplicated, as the store is not encrypted.
 func Fixture(t *testing.T) (addr string, teardown func()) {
 	pc := make([]uintptr, 10)
 	runtime.Callers(1, pc)
@@ -51,7 +66,7 @@ func Fixture(t *testing.T) (addr string, teardown func()) {
 		if f := runtime.FuncForPC(pc); f != nil {
 			if m := re.FindStringSubmatch(f.Name()); len(m) == 2 {
 				file, _ := f.FileLine(pc)
-				logfile = filepath.Join(filepath.Dir(file), "testdata", strings.ToLower(m[1])+".gzob")
+				logfile = FixturePath(filepath.Dir(file), m[1])
 			}
 		}
 	}
@@ -79,7 +94,9 @@ func Fixture(t *testing.T) (addr string, teardown func()) {
 		}()
 		addr = "http://" + p.Addr().String()
 		teardown = func() {
-			l, err := p.Stop()
+			ctx, cancel := context.WithTimeout(context.Background(), teardownTimeout)
+			defer cancel()
+			l, err := p.Shutdown(ctx)
 			if err != nil {
 				t.Fatal("fakerpc: proxy teardown error:", err)
 			}
@@ -87,7 +104,20 @@ func Fixture(t *testing.T) (addr string, teardown func()) {
 				if err = os.MkdirAll(filepath.Dir(logfile), 0755); err != nil {
 					t.Fatal("fakerpc: error creating testdata dir:", err)
 				}
-				if err = WriteLog(logfile, l); err != nil {
+				key, err := EnvKey()
+				if err != nil {
+					t.Fatal("fakerpc: error reading encryption key:", err)
+				}
+				n, _ := strconv.Atoi(os.Getenv("FAKERPC_DEDUP"))
+				switch {
+				case key != "":
+					err = WriteLogKey(logfile, l, key)
+				case n > 0:
+					err = WriteLogStore(logfile, l, n)
+				default:
+					err = WriteLog(logfile, l)
+				}
+				if err != nil {
 					t.Fatal("fakerpc: error writing log file:", err)
 				}
 			}
@@ -106,7 +136,13 @@ func Fixture(t *testing.T) (addr string, teardown func()) {
 				t.Fatal("fakerpc: server error:", err)
 			}
 		}()
-		addr, teardown = "http://"+srv.Addr().String(), func() { srv.Stop() }
+		addr, teardown = "http://"+srv.Addr().String(), func() {
+			ctx, cancel := context.WithTimeout(context.Background(), teardownTimeout)
+			defer cancel()
+			if err := srv.Shutdown(ctx); err != nil && err != ErrNotRunning {
+				t.Fatal("fakerpc: server teardown error:", err)
+			}
+		}
 	}
 	return
 }
@@ -0,0 +1,623 @@
+package fakerpc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+)
+
+// LogVersion is a version of the format WriteLog, EncodeLog and LogWriter write
+// logs in.
+//
+// A log of version 2 begins with a header, which is followed by a sequence of
+// self-contained gzip members. The first member holds log's metadata, each of
+// the following ones - transmissions of a chunk of the log, usually a single
+// connection. Thus a log can be appended to as it's recorded and remains
+// readable even if its tail got truncated. Version 3 allows for bodies being
+// kept out of the log, in a BodyStore. Version 4 allows for addresses other
+// than TCP ones, e.g. of Unix domain sockets.
+const LogVersion = 4
+
+// ErrUnknownFormat is returned by DecodeLog when the input is neither
+// a versioned log nor a legacy gzipped, gob-encoded one.
+var ErrUnknownFormat = errors.New("fakerpc: unknown log format")
+
+// logMagic begins every versioned log; it's followed by a big-endian uint16
+// version number.
+var logMagic = []byte("fakerpc\x00")
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+var errHeaderWritten = errors.New("fakerpc: log header was already written")
+
+// A logV1 is an on-disk representation of the Log. The wire types are frozen,
+// so changing the Log or the Transmission does not break existing files; such
+// a change requires a new version instead.
+type logV1 struct {
+	Networks []*net.IPNet
+	Filter   string
+	T        []transmissionV1
+}
+
+type transmissionV1 struct {
+	Src    *net.TCPAddr
+	Dst    *net.TCPAddr
+	Raw    []byte
+	Stream uint32
+	Time   time.Time
+}
+
+// A headerV2 is the first record of a log of version 2 and newer.
+type headerV2 struct {
+	Networks []*net.IPNet
+	Filter   string
+}
+
+// A chunkV3 is every next record of a log of version 2 or 3; the former lacks
+// the Body field only. Version 4 uses chunkV4 instead.
+type chunkV3 struct {
+	T []transmissionV3
+}
+
+type transmissionV3 struct {
+	Src    *net.TCPAddr
+	Dst    *net.TCPAddr
+	Raw    []byte
+	Stream uint32
+	Time   time.Time
+	// Body is a key of the body kept in a BodyStore, which was cut off the Raw;
+	// it's empty if the Raw is complete.
+	Body string
+}
+
+// A chunkV4 is every next record of a log of version 4, which holds addresses
+// of any network.
+type chunkV4 struct {
+	T []transmissionV4
+}
+
+type transmissionV4 struct {
+	Src    *addrV4
+	Dst    *addrV4
+	Raw    []byte
+	Stream uint32
+	Time   time.Time
+	Body   string
+}
+
+// An addrV4 is an on-disk representation of a net.Addr.
+type addrV4 struct {
+	Net  string
+	Addr string
+}
+
+func toAddrV4(addr net.Addr) *addrV4 {
+	if addr == nil {
+		return nil
+	}
+	return &addrV4{Net: addr.Network(), Addr: addr.String()}
+}
+
+func fromAddrV4(w *addrV4) (net.Addr, error) {
+	if w == nil {
+		return nil, nil
+	}
+	switch w.Net {
+	case "unix", "unixpacket":
+		return &net.UnixAddr{Name: w.Addr, Net: w.Net}, nil
+	default:
+		addr, err := net.ResolveTCPAddr("tcp", w.Addr)
+		if err != nil {
+			return nil, err
+		}
+		return addr, nil
+	}
+}
+
+// fromTCPAddr gives the addr as a net.Addr, which is nil if the addr is nil.
+func fromTCPAddr(addr *net.TCPAddr) net.Addr {
+	if addr == nil {
+		return nil
+	}
+	return addr
+}
+
+// toV4 converts the t, putting bodies of at least threshold bytes to the store,
+// if it's non-nil.
+func toV4(t []Transmission, store BodyStore, threshold int) ([]transmissionV4, error) {
+	w := make([]transmissionV4, 0, len(t))
+	for _, t := range t {
+		tv := transmissionV4{Src: toAddrV4(t.Src), Dst: toAddrV4(t.Dst), Raw: t.Raw, Stream: t.Stream, Time: t.Time}
+		if store != nil {
+			header, body := SplitHeaderBody(t.Raw)
+			if header == nil {
+				body = t.Raw
+			}
+			if len(body) != 0 && len(body) >= threshold {
+				key, err := store.Put(body)
+				if err != nil {
+					return nil, err
+				}
+				tv.Raw, tv.Body = header, key
+			}
+		}
+		w = append(w, tv)
+	}
+	return w, nil
+}
+
+// fromV4 converts the w, getting cut off bodies from the store.
+func fromV4(w []transmissionV4, store BodyStore) ([]Transmission, error) {
+	t := make([]Transmission, 0, len(w))
+	for _, w := range w {
+		raw, err := storedBody(w.Raw, w.Body, store)
+		if err != nil {
+			return nil, err
+		}
+		tr := Transmission{Raw: raw, Stream: w.Stream, Time: w.Time}
+		if tr.Src, err = fromAddrV4(w.Src); err != nil {
+			return nil, err
+		}
+		if tr.Dst, err = fromAddrV4(w.Dst); err != nil {
+			return nil, err
+		}
+		t = append(t, tr)
+	}
+	return t, nil
+}
+
+// fromV3 converts the w, getting cut off bodies from the store.
+func fromV3(w []transmissionV3, store BodyStore) ([]Transmission, error) {
+	t := make([]Transmission, 0, len(w))
+	for _, w := range w {
+		raw, err := storedBody(w.Raw, w.Body, store)
+		if err != nil {
+			return nil, err
+		}
+		t = append(t, Transmission{Src: fromTCPAddr(w.Src), Dst: fromTCPAddr(w.Dst), Raw: raw, Stream: w.Stream, Time: w.Time})
+	}
+	return t, nil
+}
+
+// storedBody gives the raw with the body of the key appended, if it's non-empty.
+func storedBody(raw []byte, key string, store BodyStore) ([]byte, error) {
+	if key == "" {
+		return raw, nil
+	}
+	if store == nil {
+		return nil, fmt.Errorf("fakerpc: body %s is kept out of the log, but there's no store", key)
+	}
+	body, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(make([]byte, 0, len(raw)+len(body)), raw...), body...), nil
+}
+
+func fromV1(w []transmissionV1) []Transmission {
+	t := make([]Transmission, 0, len(w))
+	for _, w := range w {
+		t = append(t, Transmission{Src: fromTCPAddr(w.Src), Dst: fromTCPAddr(w.Dst), Raw: w.Raw, Stream: w.Stream, Time: w.Time})
+	}
+	return t
+}
+
+// decoders holds a decoder for every supported version of the format, which is
+// read as a whole. Version 0 is a legacy headerless format, which is gzipped,
+// gob-encoded Log struct.
+var decoders = map[int]func(r io.Reader) (*Log, error){
+	0: decodeV1,
+	1: decodeV1,
+}
+
+// decodeV1 decodes gzipped, gob-encoded logV1. The legacy format is decoded with
+// it as well, since gob ignores fields missing in the input.
+func decodeV1(r io.Reader) (*Log, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	var w logV1
+	if err = gob.NewDecoder(gz).Decode(&w); err != nil {
+		return nil, err
+	}
+	return &Log{Networks: w.Networks, Filter: w.Filter, T: fromV1(w.T)}, nil
+}
+
+// A LogSink receives a log chunk by chunk, e.g. from a recording Proxy.
+type LogSink interface {
+	// WriteHeader writes log's metadata; it's called before the first Write.
+	WriteHeader(networks []*net.IPNet, filter string) error
+	// Write appends transmissions of a single chunk of the log.
+	Write(t []Transmission) error
+	// Err gives the first error, which occurred while writing the log.
+	Err() error
+}
+
+// A LogWriter is a LogSink, which writes a log chunk by chunk, so it does not
+// need to be held in memory as a whole. It's safe for concurrent use.
+type LogWriter struct {
+	// SyncInterval, when positive, makes the LogWriter sync the underlying
+	// writer, if it has a Sync method, at most SyncInterval after a chunk was
+	// written, even if no other chunk follows.
+	SyncInterval time.Duration
+	// Store, when non-nil, keeps bodies of at least Threshold bytes out of
+	// the log; the log then references them by a key.
+	Store     BodyStore
+	Threshold int
+	w         io.Writer
+	m         sync.Mutex
+	hdr       bool
+	last      time.Time
+	dirty     bool        // whether chunks were written since the last sync
+	timer     *time.Timer // pending sync of the written chunks
+	err       error
+}
+
+// NewLogWriter gives a new LogWriter, which writes to the w in the LogVersion
+// format.
+func NewLogWriter(w io.Writer) *LogWriter {
+	return &LogWriter{w: w, last: time.Now()}
+}
+
+// record writes the v as a self-contained gzip member with a single call to
+// the underlying writer, so an interrupted write leaves at most one member
+// truncated.
+func (lw *LogWriter) record(v interface{}) error {
+	if lw.err != nil {
+		return lw.err
+	}
+	var buf bytes.Buffer
+	if !lw.hdr {
+		var hdr [10]byte
+		copy(hdr[:], logMagic)
+		binary.BigEndian.PutUint16(hdr[len(logMagic):], LogVersion)
+		buf.Write(hdr[:])
+		lw.hdr = true
+		if _, ok := v.(*headerV2); !ok {
+			if lw.err = encodeMember(&buf, &headerV2{}); lw.err != nil {
+				return lw.err
+			}
+		}
+	}
+	if lw.err = encodeMember(&buf, v); lw.err != nil {
+		return lw.err
+	}
+	_, lw.err = lw.w.Write(buf.Bytes())
+	return lw.err
+}
+
+func encodeMember(w io.Writer, v interface{}) error {
+	gz, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	if err = gob.NewEncoder(gz).Encode(v); err != nil {
+		gz.Close()
+		return err
+	}
+	return gz.Close()
+}
+
+// WriteHeader writes log's metadata. It must be called before the first Write,
+// otherwise the log is written with empty metadata.
+func (lw *LogWriter) WriteHeader(networks []*net.IPNet, filter string) error {
+	lw.m.Lock()
+	defer lw.m.Unlock()
+	if lw.hdr {
+		return errHeaderWritten
+	}
+	return lw.record(&headerV2{Networks: networks, Filter: filter})
+}
+
+// Write appends the t as a single chunk to the log.
+func (lw *LogWriter) Write(t []Transmission) error {
+	lw.m.Lock()
+	defer lw.m.Unlock()
+	if lw.err != nil {
+		return lw.err
+	}
+	w, err := toV4(t, lw.Store, lw.Threshold)
+	if err != nil {
+		lw.err = err
+		return err
+	}
+	if err = lw.record(&chunkV4{T: w}); err != nil {
+		return err
+	}
+	lw.dirty = true
+	if lw.SyncInterval > 0 {
+		d := time.Since(lw.last)
+		if d > lw.SyncInterval {
+			return lw.sync()
+		}
+		if lw.timer == nil {
+			lw.timer = time.AfterFunc(lw.SyncInterval-d, lw.flush)
+		}
+	}
+	return nil
+}
+
+// flush syncs the chunks written since the last sync, once SyncInterval
+// passed.
+func (lw *LogWriter) flush() {
+	lw.m.Lock()
+	defer lw.m.Unlock()
+	lw.timer = nil
+	if lw.dirty && time.Since(lw.last) >= lw.SyncInterval {
+		lw.sync()
+	}
+}
+
+// Sync commits written chunks to a stable storage, if the underlying writer
+// has a Sync method.
+func (lw *LogWriter) Sync() error {
+	lw.m.Lock()
+	defer lw.m.Unlock()
+	return lw.sync()
+}
+
+func (lw *LogWriter) sync() error {
+	lw.last, lw.dirty = time.Now(), false
+	if lw.timer != nil {
+		lw.timer.Stop()
+		lw.timer = nil
+	}
+	if s, ok := lw.w.(interface {
+		Sync() error
+	}); ok && lw.err == nil {
+		lw.err = s.Sync()
+	}
+	return lw.err
+}
+
+// Err gives the first error, which occurred while writing the log.
+func (lw *LogWriter) Err() error {
+	lw.m.Lock()
+	defer lw.m.Unlock()
+	return lw.err
+}
+
+// Close writes the header if nothing was written yet and syncs the log. It
+// closes the underlying writer if it's an io.Closer.
+func (lw *LogWriter) Close() error {
+	lw.m.Lock()
+	defer lw.m.Unlock()
+	if !lw.hdr {
+		lw.record(&headerV2{})
+	}
+	lw.sync()
+	if c, ok := lw.w.(io.Closer); ok {
+		if err := c.Close(); lw.err == nil {
+			lw.err = err
+		}
+	}
+	return lw.err
+}
+
+// A LogReader reads a log chunk by chunk, so it does not need to be held in
+// memory as a whole. Logs of versions older than 2 are read at once, as
+// a single chunk.
+type LogReader struct {
+	// Networks and Filter hold log's metadata.
+	Networks []*net.IPNet
+	Filter   string
+	// Store is used to get bodies kept out of the log.
+	Store   BodyStore
+	version int
+	br      *bufio.Reader
+	gz      *gzip.Reader
+	log     *Log
+	trunc   bool
+	err     error
+	stored  int // size of the smallest body got from the Store
+}
+
+// NewLogReader gives a new LogReader, which reads the log in any of
+// the supported versions from the r. ErrUnknownFormat is returned if the r
+// does not contain a log. An encrypted log is decrypted with a key given by
+// EnvKey.
+func NewLogReader(r io.Reader) (*LogReader, error) {
+	lr := &LogReader{br: bufio.NewReader(r)}
+	if IsEncrypted(lr.br) {
+		key, err := EnvKey()
+		if err != nil {
+			return nil, err
+		}
+		if r, err = Decrypt(lr.br, key); err != nil {
+			return nil, err
+		}
+		lr.br = bufio.NewReader(r)
+	}
+	p, _ := lr.br.Peek(len(logMagic) + 2)
+	switch {
+	case bytes.HasPrefix(p, logMagic) && len(p) == len(logMagic)+2:
+		lr.version = int(binary.BigEndian.Uint16(p[len(logMagic):]))
+		lr.br.Discard(len(p))
+	case bytes.HasPrefix(p, gzipMagic):
+		lr.version = 0
+	default:
+		return nil, ErrUnknownFormat
+	}
+	if lr.version >= 2 && lr.version <= 4 {
+		var hdr headerV2
+		if err := lr.member(&hdr); err != nil {
+			return nil, fmt.Errorf("fakerpc: error decoding log version %d: %v", lr.version, err)
+		}
+		lr.Networks, lr.Filter = hdr.Networks, hdr.Filter
+		return lr, nil
+	}
+	dec, ok := decoders[lr.version]
+	if !ok {
+		return nil, fmt.Errorf("fakerpc: unsupported log version %d (the newest supported is %d)",
+			lr.version, LogVersion)
+	}
+	l, err := dec(lr.br)
+	if err != nil {
+		return nil, fmt.Errorf("fakerpc: error decoding log version %d: %v", lr.version, err)
+	}
+	lr.Networks, lr.Filter, lr.log = l.Networks, l.Filter, l
+	return lr, nil
+}
+
+// member decodes the v from the next gzip member. It returns io.EOF if there
+// are no more members.
+func (lr *LogReader) member(v interface{}) (err error) {
+	if lr.gz == nil {
+		lr.gz, err = gzip.NewReader(lr.br)
+	} else {
+		err = lr.gz.Reset(lr.br)
+	}
+	if err != nil {
+		return err
+	}
+	lr.gz.Multistream(false)
+	if err = gob.NewDecoder(lr.gz).Decode(v); err != nil {
+		return err
+	}
+	// Reading the member up to its end verifies its checksum.
+	_, err = io.Copy(ioutil.Discard, lr.gz)
+	return err
+}
+
+// Version gives a version of the log format.
+func (lr *LogReader) Version() int {
+	return lr.version
+}
+
+// Next gives transmissions of the next chunk of the log. It returns io.EOF when
+// there are no more chunks. A chunk, which can't be read, is treated as
+// a truncated end of the log if nothing follows it; otherwise it's reported
+// as an error, since all the following chunks would be lost.
+func (lr *LogReader) Next() ([]Transmission, error) {
+	if lr.version < 2 {
+		if lr.log == nil {
+			return nil, io.EOF
+		}
+		t := lr.log.T
+		lr.log = nil
+		return t, nil
+	}
+	if lr.trunc {
+		return nil, io.EOF
+	}
+	if lr.err != nil {
+		return nil, lr.err
+	}
+	var (
+		t   []Transmission
+		raw = make(map[int]int) // sizes of raws cut off bodies kept in the Store
+		err error
+	)
+	if lr.version < 4 {
+		var chunk chunkV3
+		if err := lr.member(&chunk); err != nil {
+			return nil, lr.truncate(err)
+		}
+		for i, w := range chunk.T {
+			if w.Body != "" {
+				raw[i] = len(w.Raw)
+			}
+		}
+		t, err = fromV3(chunk.T, lr.Store)
+	} else {
+		var chunk chunkV4
+		if err := lr.member(&chunk); err != nil {
+			return nil, lr.truncate(err)
+		}
+		for i, w := range chunk.T {
+			if w.Body != "" {
+				raw[i] = len(w.Raw)
+			}
+		}
+		t, err = fromV4(chunk.T, lr.Store)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, n := range raw {
+		if n = len(t[i].Raw) - n; lr.stored == 0 || n < lr.stored {
+			lr.stored = n
+		}
+	}
+	return t, nil
+}
+
+// Stored gives a size of the smallest body got from the Store by the chunks read
+// so far; it's 0 if all of them kept their bodies.
+func (lr *LogReader) Stored() int {
+	return lr.stored
+}
+
+// truncate gives io.EOF if the err, which a chunk failed to decode with, is
+// io.EOF or the log ends within the chunk; the latter marks the log as
+// truncated. Otherwise the chunk is corrupted and an error is given.
+func (lr *LogReader) truncate(err error) error {
+	if err == io.EOF {
+		return io.EOF
+	}
+	if _, e := lr.br.Peek(1); e == io.EOF || e == io.ErrUnexpectedEOF {
+		lr.trunc = true
+		return io.EOF
+	}
+	lr.err = fmt.Errorf("fakerpc: corrupted log chunk: %v", err)
+	return lr.err
+}
+
+// Truncated reports whether Next stopped reading at a truncated chunk at the end
+// of the log, e.g. when recording process was killed.
+func (lr *LogReader) Truncated() bool {
+	return lr.trunc
+}
+
+// EncodeLog writes the l to the w in the LogVersion format.
+func EncodeLog(w io.Writer, l *Log) error {
+	lw := NewLogWriter(w)
+	if err := lw.WriteHeader(l.Networks, l.Filter); err != nil {
+		return err
+	}
+	return lw.Write(l.T)
+}
+
+// DecodeLog reads a log in any of the supported versions, migrating it to
+// the Log. It gives version of the read format as well. ErrUnknownFormat is
+// returned if the r does not contain a log. Truncated tail of the log is
+// ignored; a corrupted chunk followed by other ones is reported as an error.
+func DecodeLog(r io.Reader) (*Log, int, error) {
+	l, lr, err := decodeLog(r, nil)
+	if lr == nil {
+		return nil, 0, err
+	}
+	return l, lr.version, err
+}
+
+// decodeLog reads a log from the r, giving the reader it was read with.
+func decodeLog(r io.Reader, store BodyStore) (*Log, *LogReader, error) {
+	lr, err := NewLogReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	lr.Store = store
+	l := &Log{Networks: lr.Networks, Filter: lr.Filter, T: make([]Transmission, 0)}
+	for {
+		t, err := lr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, lr, err
+		}
+		l.T = append(l.T, t...)
+	}
+	return l, lr, nil
+}
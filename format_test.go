@@ -0,0 +1,261 @@
+package fakerpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeDecodeLog(t *testing.T) {
+	l := log.Map(func(tr Transmission) Transmission {
+		tr.Time = time.Unix(1400000000, 0).UTC()
+		return tr
+	})
+	var buf bytes.Buffer
+	if err := EncodeLog(&buf, l); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), logMagic) {
+		t.Fatalf("expected log to begin with %q; got %q", logMagic, buf.Bytes()[:len(logMagic)])
+	}
+	dl, version, err := DecodeLog(&buf)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if version != LogVersion {
+		t.Errorf("expected version=%d; got %d", LogVersion, version)
+	}
+	if !reflect.DeepEqual(dl.T, l.T) {
+		t.Errorf("expected dl.T=%v; got %v", l.T, dl.T)
+	}
+}
+
+func TestDecodeLogLegacy(t *testing.T) {
+	// Legacy logs are headerless gzipped, gob-encoded structs.
+	type transmission struct {
+		Src, Dst *net.TCPAddr
+		Raw      []byte
+	}
+	type legacy struct {
+		Networks []*net.IPNet
+		Filter   string
+		T        []transmission
+	}
+	old := legacy{Filter: "port 80"}
+	for _, tr := range log.T {
+		old.T = append(old.T, transmission{Src: tr.Src.(*net.TCPAddr), Dst: tr.Dst.(*net.TCPAddr), Raw: tr.Raw})
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := gob.NewEncoder(gz).Encode(old); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	gz.Close()
+	l, version, err := DecodeLog(&buf)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if version != 0 {
+		t.Errorf("expected version=0; got %d", version)
+	}
+	if l.Filter != old.Filter || len(l.T) != len(log.T) {
+		t.Fatalf("expected Filter=%q, len(T)=%d; got %q, %d", old.Filter, len(log.T), l.Filter, len(l.T))
+	}
+	for i := range l.T {
+		if !bytes.Equal(l.T[i].Raw, log.T[i].Raw) || !addrequal(l.T[i].Src, log.T[i].Src) {
+			t.Errorf("expected l.T[%d]=%v; got %v", i, log.T[i], l.T[i])
+		}
+	}
+}
+
+func TestDecodeLogErr(t *testing.T) {
+	cases := [...]struct {
+		p   []byte
+		err string
+	}{
+		{[]byte("interface: dunno0"), ErrUnknownFormat.Error()},
+		{append(append([]byte{}, logMagic...), 0xff, 0xff), "unsupported log version 65535"},
+		{append(append([]byte{}, logMagic...), 0, 1, 'x'), "error decoding log version 1"},
+		{[]byte{0x1f, 0x8b, 0, 0}, "error decoding log version 0"},
+	}
+	for i, cas := range cases {
+		_, _, err := DecodeLog(bytes.NewReader(cas.p))
+		if err == nil || !strings.Contains(err.Error(), cas.err) {
+			t.Errorf("expected err=%q; got %v (i=%d)", cas.err, err, i)
+		}
+	}
+}
+
+func TestReadLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakerpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string][]byte{
+		"ngrep":   ngrep,
+		"corrupt": append(append([]byte{}, logMagic...), 0, 1, 0x1f, 0x8b),
+	}
+	for name, p := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), p, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l, err := ReadLog(filepath.Join(dir, "ngrep"))
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if len(l.T) != len(lexp.T) {
+		t.Errorf("expected len(l.T)=%d; got %d", len(lexp.T), len(l.T))
+	}
+	var buf bytes.Buffer
+	if err = JSONMarshal(&buf, log); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "json"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if l, err = ReadLog(filepath.Join(dir, "json")); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if len(l.T) != len(log.T) {
+		t.Errorf("expected len(l.T)=%d; got %d", len(log.T), len(l.T))
+	}
+	if _, err = ReadLog(filepath.Join(dir, "corrupt")); err == nil {
+		t.Error("expected err!=nil for a corrupted log")
+	}
+	file := filepath.Join(dir, "log.gzob")
+	if err = WriteLog(file, log); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if l, err = ReadLog(file); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if len(l.T) != len(log.T) {
+		t.Errorf("expected len(l.T)=%d; got %d", len(log.T), len(l.T))
+	}
+}
+
+func TestLogWriter(t *testing.T) {
+	var buf bytes.Buffer
+	lw := NewLogWriter(&buf)
+	if err := lw.WriteHeader(lexp.Networks, lexp.Filter); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if err := lw.WriteHeader(nil, ""); err != errHeaderWritten {
+		t.Errorf("expected err=errHeaderWritten; got %v", err)
+	}
+	var n []int
+	for _, conn := range log.Conns() {
+		if err := lw.Write(conn.T); err != nil {
+			t.Fatalf("expected err=nil; got %q", err)
+		}
+		n = append(n, buf.Len())
+	}
+	if err := lw.Close(); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	cases := [...]struct {
+		size  int
+		n     int
+		trunc bool
+	}{
+		{n[2], 10, false},
+		{n[2] - 1, 6, true},
+		{n[1] + 3, 6, true},
+		{n[0], 4, false},
+	}
+	for i, cas := range cases {
+		lr, err := NewLogReader(bytes.NewReader(buf.Bytes()[:cas.size]))
+		if err != nil {
+			t.Errorf("expected err=nil; got %q (i=%d)", err, i)
+			continue
+		}
+		if lr.Filter != lexp.Filter || len(lr.Networks) != 1 {
+			t.Errorf("expected Filter=%q, len(Networks)=1; got %q, %d (i=%d)", lexp.Filter,
+				lr.Filter, len(lr.Networks), i)
+		}
+		var tr []Transmission
+		for {
+			chunk, err := lr.Next()
+			if err != nil {
+				break
+			}
+			tr = append(tr, chunk...)
+		}
+		if len(tr) != cas.n {
+			t.Errorf("expected len(tr)=%d; got %d (i=%d)", cas.n, len(tr), i)
+		}
+		if lr.Truncated() != cas.trunc {
+			t.Errorf("expected Truncated()=%v; got %v (i=%d)", cas.trunc, lr.Truncated(), i)
+		}
+		if _, err = NewConnections(&Log{T: tr}); err != nil {
+			t.Errorf("expected err=nil; got %q (i=%d)", err, i)
+		}
+	}
+	// A corrupted chunk followed by other ones is not a truncated tail.
+	p := append([]byte{}, buf.Bytes()...)
+	p[n[1]-10] ^= 0xff
+	if _, _, err := DecodeLog(bytes.NewReader(p)); err == nil {
+		t.Error("expected err!=nil for a log corrupted in the middle")
+	}
+	dir, err := ioutil.TempDir("", "fakerpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "log.gzob")
+	if err = ioutil.WriteFile(file, buf.Bytes()[:n[2]-1], 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, lf, err := ReadLogFile(file)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if len(l.T) != 6 || !lf.Truncated || lf.Version != LogVersion || lf.Codec.Name != "gob" {
+		t.Errorf("expected 6 transmissions of a truncated gob log; got %d, %+v", len(l.T), lf)
+	}
+}
+
+// syncbuf is a bytes.Buffer, which signals its syncs.
+type syncbuf struct {
+	bytes.Buffer
+	c chan struct{}
+}
+
+func (sb *syncbuf) Sync() error {
+	sb.c <- struct{}{}
+	return nil
+}
+
+func TestLogWriterSyncInterval(t *testing.T) {
+	sb := &syncbuf{c: make(chan struct{}, 8)}
+	lw := NewLogWriter(sb)
+	lw.SyncInterval = 50 * time.Millisecond
+	start := time.Now()
+	if err := lw.Write(log.Conns()[0].T); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	select {
+	case <-sb.c:
+		if d := time.Since(start); d < lw.SyncInterval {
+			t.Errorf("expected sync after %v; got after %v", lw.SyncInterval, d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the written chunk to be synced")
+	}
+	if err := lw.Close(); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if len(sb.c) != 1 {
+		t.Errorf("expected single sync on Close; got %d", len(sb.c))
+	}
+}
@@ -0,0 +1,197 @@
+package fakerpc
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// FixturePath gives a path of the record-log, which Fixture looks up for
+// the test function name called from a _test.go file in the dir.
+func FixturePath(dir, name string) string {
+	return filepath.Join(dir, "testdata", strings.ToLower(name)+".gzob")
+}
+
+// A genCall is a single call issued by a generated test.
+type genCall struct {
+	Method string
+	URI    string
+	Header [][2]string
+	Body   string
+	Status int
+	Skip   string // reason the call is not issued
+}
+
+// A genConn is a single connection of a generated test.
+type genConn struct {
+	Calls  []genCall
+	Issued bool // whether any of the calls is issued
+}
+
+var gentmpl = template.Must(template.New("").Parse(`// Code generated by fakerpc gen-test; edit freely.
+
+package {{.Package}}
+
+import (
+	"io/ioutil"
+	"net/http"
+{{- if .Body}}
+	"strings"
+{{- end}}
+	"testing"
+
+	"github.com/rjeczalik/fakerpc"
+)
+
+func {{.Name}}(t *testing.T) {
+	addr, teardown := fakerpc.Fixture(t)
+	defer teardown()
+	// Requests are issued over as many connections as they were recorded with,
+	// as the fake replies to connections in order. Connections with no calls
+	// issued are left out of the log.
+{{- range .Conns}}
+{{- if not .Issued}}
+{{- range .Calls}}
+	// {{.Method}} {{.URI}} is not issued: {{.Skip}}.
+{{- end}}
+{{- else}}
+	{
+		tr := &http.Transport{}
+		client := &http.Client{Transport: tr}
+{{- range .Calls}}
+{{- if .Skip}}
+		// {{.Method}} {{.URI}} is not issued: {{.Skip}}.
+{{- else}}
+		{
+			req, err := http.NewRequest({{printf "%q" .Method}}, addr+{{printf "%q" .URI}}, {{if .Body}}strings.NewReader({{printf "%q" .Body}}){{else}}nil{{end}})
+			if err != nil {
+				t.Fatal(err)
+			}
+{{- range .Header}}
+			req.Header.Add({{printf "%q" (index . 0)}}, {{printf "%q" (index . 1)}})
+{{- end}}
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+{{- if .Status}}
+			if res.StatusCode != {{.Status}} {
+				t.Errorf("%s: expected StatusCode=%d; got %d", {{printf "%q" (print .Method " " .URI)}}, {{.Status}}, res.StatusCode)
+			}
+{{- end}}
+			_ = body // TODO: assert the body
+		}
+{{- end}}
+{{- end}}
+		tr.CloseIdleConnections()
+	}
+{{- end}}
+{{- end}}
+}
+`))
+
+// GenTest writes to the w a Go test file of the package pkg with the test
+// function name, which uses Fixture to reply to calls recorded in the l and
+// issues every recorded request with a http.Client, checking the status of
+// its response. Tunnelled calls are not issued. The log given by GenTestLog
+// is expected to be written to FixturePath.
+func GenTest(w io.Writer, pkg, name string, l *Log) error {
+	if !token.IsIdentifier(pkg) {
+		return fmt.Errorf("fakerpc: invalid package name %q", pkg)
+	}
+	if !strings.HasPrefix(name, "Test") || !token.IsIdentifier(name) {
+		return fmt.Errorf("fakerpc: invalid test function name %q", name)
+	}
+	conns, err := NewConnections(l)
+	if err != nil {
+		return err
+	}
+	data := struct {
+		Package, Name string
+		Conns         []genConn
+		Body          bool
+	}{Package: pkg, Name: name}
+	for _, conn := range conns {
+		var gconn genConn
+		for _, c := range conn {
+			gc := genCall{
+				Method: c.Req.Method,
+				URI:    c.Req.URL.RequestURI(),
+				Body:   string(c.ReqBody),
+				Skip:   genskip(&c),
+			}
+			if c.Res != nil {
+				gc.Status = statusCode(c.Res)
+			}
+			var keys []string
+			for k := range c.Req.Header {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				switch k {
+				case "Host", "Content-Length", "Connection", "Transfer-Encoding":
+					continue
+				}
+				for _, v := range c.Req.Header[k] {
+					gc.Header = append(gc.Header, [2]string{k, v})
+				}
+			}
+			data.Body = data.Body || (gc.Body != "" && gc.Skip == "")
+			gconn.Issued = gconn.Issued || gc.Skip == ""
+			gconn.Calls = append(gconn.Calls, gc)
+		}
+		data.Conns = append(data.Conns, gconn)
+	}
+	var buf bytes.Buffer
+	if err = gentmpl.Execute(&buf, data); err != nil {
+		return err
+	}
+	p, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("fakerpc: error formatting generated test: %v", err)
+	}
+	_, err = w.Write(p)
+	return err
+}
+
+// GenTestLog gives the l without the connections GenTest issues no calls over.
+// Since the fake replies to connections in order, it's the log the generated
+// test expects to find at FixturePath.
+func GenTestLog(l *Log) *Log {
+	return l.WhereConn(func(conn *Log) bool {
+		c, err := NewConnections(conn)
+		if err != nil {
+			return true
+		}
+		for _, c := range c[0] {
+			if genskip(&c) == "" {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// genskip gives a reason the generated test does not issue the c, or an empty
+// string if it does.
+func genskip(c *Connection) string {
+	switch {
+	case tunnelled(c):
+		return "tunnelled connections can't be issued by http.Client"
+	case c.Req.ProtoMajor == 2:
+		return "it was recorded over HTTP/2"
+	}
+	return ""
+}
@@ -0,0 +1,54 @@
+package fakerpc
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenTest(t *testing.T) {
+	var buf bytes.Buffer
+	if err := GenTest(&buf, "api", "TestUsers", log); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "users_test.go", buf.Bytes(), 0); err != nil {
+		t.Fatalf("expected generated test to parse; got %q:\n%s", err, buf.Bytes())
+	}
+	src := buf.String()
+	for _, exp := range []string{
+		"package api\n",
+		"func TestUsers(t *testing.T) {",
+		"fakerpc.Fixture(t)",
+		`http.NewRequest("POST", addr+"/2", strings.NewReader("BAAI"))`,
+	} {
+		if !strings.Contains(src, exp) {
+			t.Errorf("expected generated test to contain %q:\n%s", exp, src)
+		}
+	}
+	if strings.Contains(src, `"Connection"`) {
+		t.Errorf("expected Connection header to be skipped:\n%s", src)
+	}
+	if n := strings.Count(src, "tr.CloseIdleConnections()"); n != 3 {
+		t.Errorf("expected 3 connections; got %d", n)
+	}
+	for _, cas := range [][2]string{{"api", "Users"}, {"a-b", "TestUsers"}, {"api", "Test Users"}} {
+		if err := GenTest(&buf, cas[0], cas[1], log); err == nil {
+			t.Errorf("expected err!=nil (pkg=%q, name=%q)", cas[0], cas[1])
+		}
+	}
+	tun := &net.TCPAddr{IP: net.IPv4(192, 168, 14, 186), Port: 46796}
+	l := &Log{T: append([]Transmission{
+		{Src: tun, Dst: srv, Raw: []byte("CONNECT api.local:443 HTTP/1.1\r\n\r\n")},
+		{Src: srv, Dst: tun, Raw: []byte("HTTP/1.1 200 OK\r\n\r\n")},
+	}, log.T...)}
+	if gl := GenTestLog(l); len(gl.T) != len(log.T) || !bytes.Equal(gl.T[0].Raw, log.T[0].Raw) {
+		t.Errorf("expected GenTestLog to leave out the tunnelled connection; got %v", gl.T)
+	}
+	if p := FixturePath("x", "TestUsers"); p != filepath.Join("x", "testdata", "testusers.gzob") {
+		t.Errorf("expected FixturePath=x/testdata/testusers.gzob; got %q", p)
+	}
+}
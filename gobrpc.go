@@ -0,0 +1,338 @@
+package fakerpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+)
+
+var errGobMessage = errors.New("fakerpc: ill-formed gob message")
+
+// A GobCall represents a single net/rpc call decoded from a gob stream, which
+// was tunneled over a HTTP CONNECT request.
+type GobCall struct {
+	ServiceMethod string // name of the service and method to call
+	Seq           uint64 // sequence number chosen by the client
+	Error         string // error returned by the server, if any
+	Args          []byte // self-contained gob stream holding the argument
+	Reply         []byte // self-contained gob stream holding the reply
+	hdr           []byte // self-contained gob stream holding the response header
+}
+
+// DecodeArgs decodes the argument of the call into v.
+func (gc *GobCall) DecodeArgs(v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(gc.Args)).Decode(v)
+}
+
+// DecodeReply decodes the reply of the call into v.
+func (gc *GobCall) DecodeReply(v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(gc.Reply)).Decode(v)
+}
+
+// NewGobCalls decodes net/rpc calls from the transmissions of a tunnel. It
+// assumes the first transmission was sent by the client.
+func NewGobCalls(tunnel []Transmission) ([]GobCall, error) {
+	if len(tunnel) == 0 {
+		return nil, errors.New("fakerpc: tunnel is empty")
+	}
+	var cli, srv bytes.Buffer
+	for i := range tunnel {
+		if addrequal(tunnel[i].Src, tunnel[0].Src) {
+			cli.Write(tunnel[i].Raw)
+		} else {
+			srv.Write(tunnel[i].Raw)
+		}
+	}
+	var (
+		calls []GobCall
+		index = make(map[uint64]int)
+		r     = newGobReader(bufio.NewReader(&cli))
+	)
+	for {
+		hdr, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var req rpc.Request
+		if err = gob.NewDecoder(bytes.NewReader(hdr)).Decode(&req); err != nil {
+			return nil, err
+		}
+		args, err := r.next()
+		if err != nil {
+			return nil, errGobMessage
+		}
+		index[req.Seq] = len(calls)
+		calls = append(calls, GobCall{ServiceMethod: req.ServiceMethod, Seq: req.Seq, Args: args})
+	}
+	r = newGobReader(bufio.NewReader(&srv))
+	for {
+		hdr, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var res rpc.Response
+		if err = gob.NewDecoder(bytes.NewReader(hdr)).Decode(&res); err != nil {
+			return nil, err
+		}
+		reply, err := r.next()
+		if err != nil {
+			return nil, errGobMessage
+		}
+		n, ok := index[res.Seq]
+		if !ok {
+			return nil, errors.New("fakerpc: response to a request which was not recorded")
+		}
+		calls[n].Error, calls[n].Reply, calls[n].hdr = res.Error, reply, hdr
+	}
+	return calls, nil
+}
+
+// A gobReader splits gob stream into self-contained streams, each holding
+// a single value preceded by all type definitions read so far.
+type gobReader struct {
+	r    *bufio.Reader
+	defs []byte
+}
+
+func newGobReader(r *bufio.Reader) *gobReader {
+	return &gobReader{r: r}
+}
+
+func (gr *gobReader) next() ([]byte, error) {
+	for {
+		msg, id, err := readGobMessage(gr.r)
+		if err != nil {
+			return nil, err
+		}
+		if id < 0 {
+			gr.defs = append(gr.defs, msg...)
+			continue
+		}
+		p := make([]byte, 0, len(gr.defs)+len(msg))
+		return append(append(p, gr.defs...), msg...), nil
+	}
+}
+
+// A gobWriter writes self-contained gob streams as a single stream, omitting
+// type definitions which were already sent.
+type gobWriter struct {
+	w    io.Writer
+	sent map[int]struct{}
+}
+
+func newGobWriter(w io.Writer) *gobWriter {
+	return &gobWriter{w: w, sent: make(map[int]struct{})}
+}
+
+// write writes the p stream, replacing its value message with v if non-nil.
+func (gw *gobWriter) write(p, v []byte) (n int64, err error) {
+	var buf bytes.Buffer
+	r := bufio.NewReader(bytes.NewReader(p))
+	for {
+		msg, id, err := readGobMessage(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if id < 0 {
+			if _, ok := gw.sent[-id]; ok {
+				continue
+			}
+			gw.sent[-id] = struct{}{}
+		} else if v != nil {
+			msg = v
+		}
+		buf.Write(msg)
+	}
+	return io.Copy(gw.w, &buf)
+}
+
+// serveGob replies to net/rpc calls read from r with the recorded ones, which
+// are matched by a service method and arguments, regardless of a sequence
+// number. It returns when reading from r fails.
+func (srv *Server) serveGob(rw io.Writer, r *bufio.Reader, calls []GobCall, rem net.Addr) error {
+	var (
+		gr   = newGobReader(r)
+		gw   = newGobWriter(rw)
+		used = make([]bool, len(calls))
+	)
+	for {
+		hdr, err := gr.next()
+		if err != nil {
+			return err
+		}
+		var req rpc.Request
+		if err = gob.NewDecoder(bytes.NewReader(hdr)).Decode(&req); err != nil {
+			return err
+		}
+		args, err := gr.next()
+		if err != nil {
+			return err
+		}
+		n := matchGobCall(calls, used, req.ServiceMethod, args)
+		if n == -1 {
+			if len(calls) == 0 || calls[0].hdr == nil {
+				return errNoResponse
+			}
+			srv.Reply(rem, srv.src, int64(len(args)), errNoResponse)
+			id, _ := gobValue(calls[0].hdr)
+			res := gobResponse(id, req.ServiceMethod, req.Seq, errNoResponse.Error())
+			if _, err = gw.write(calls[0].hdr, res); err != nil {
+				return err
+			}
+			if _, err = rw.Write(gobFalse); err != nil {
+				return err
+			}
+			continue
+		}
+		srv.Reply(rem, srv.src, int64(len(args)), nil)
+		used[n] = true
+		id, _ := gobValue(calls[n].hdr)
+		res := gobResponse(id, calls[n].ServiceMethod, req.Seq, calls[n].Error)
+		m, err := gw.write(calls[n].hdr, res)
+		if err != nil {
+			return err
+		}
+		k, err := gw.write(calls[n].Reply, nil)
+		srv.Reply(srv.src, rem, m+k, err)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// matchGobCall gives an index of the first unused call for the given method
+// and arguments or -1 if none matches.
+func matchGobCall(calls []GobCall, used []bool, method string, args []byte) int {
+	_, v := gobValue(args)
+	for i := range calls {
+		if used[i] || calls[i].hdr == nil || calls[i].ServiceMethod != method {
+			continue
+		}
+		if _, w := gobValue(calls[i].Args); bytes.Equal(v, w) {
+			return i
+		}
+	}
+	return -1
+}
+
+// gobFalse is a gob message holding a false bool value, which net/rpc clients
+// discard as a body of an error response.
+var gobFalse = []byte{0x03, 0x02, 0x00, 0x00}
+
+// gobResponse encodes a message with rpc.Response value of the given type id.
+func gobResponse(id int, method string, seq uint64, errmsg string) []byte {
+	p, last := gobPutInt(nil, int64(id)), -1
+	if method != "" {
+		p = gobPutUint(p, uint64(0-last))
+		p = gobPutUint(p, uint64(len(method)))
+		p, last = append(p, method...), 0
+	}
+	if seq != 0 {
+		p = gobPutUint(p, uint64(1-last))
+		p, last = gobPutUint(p, seq), 1
+	}
+	if errmsg != "" {
+		p = gobPutUint(p, uint64(2-last))
+		p = gobPutUint(p, uint64(len(errmsg)))
+		p = append(p, errmsg...)
+	}
+	p = append(p, 0)
+	return append(gobPutUint(nil, uint64(len(p))), p...)
+}
+
+// gobValue gives a type id and a payload of the last message of the stream.
+func gobValue(p []byte) (id int, v []byte) {
+	r := bufio.NewReader(bytes.NewReader(p))
+	for {
+		msg, n, err := readGobMessage(r)
+		if err != nil {
+			return
+		}
+		id, v = n, msg
+		_, k, _ := gobUint(msg)
+		_, l, _ := gobUint(msg[k:])
+		v = msg[k+l:]
+	}
+}
+
+// readGobMessage reads a single message from the gob stream, returning it
+// along with the type id.
+func readGobMessage(r *bufio.Reader) (msg []byte, id int, err error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, 0, err
+	}
+	k := 1
+	if b[0] >= 0x80 {
+		k += int(-int8(b[0]))
+	}
+	if b, err = r.Peek(k); err != nil {
+		return nil, 0, errGobMessage
+	}
+	n, _, err := gobUint(b)
+	if err != nil || n > 1<<30 {
+		return nil, 0, errGobMessage
+	}
+	msg = make([]byte, k+int(n))
+	if _, err = io.ReadFull(r, msg); err != nil {
+		return nil, 0, errGobMessage
+	}
+	u, _, err := gobUint(msg[k:])
+	if err != nil {
+		return nil, 0, err
+	}
+	if u&1 != 0 {
+		return msg, int(^(u >> 1)), nil
+	}
+	return msg, int(u >> 1), nil
+}
+
+func gobUint(p []byte) (u uint64, n int, err error) {
+	if len(p) == 0 {
+		return 0, 0, errGobMessage
+	}
+	if p[0] < 0x80 {
+		return uint64(p[0]), 1, nil
+	}
+	n = int(-int8(p[0]))
+	if n > 8 || len(p) < n+1 {
+		return 0, 0, errGobMessage
+	}
+	for _, b := range p[1 : n+1] {
+		u = u<<8 | uint64(b)
+	}
+	return u, n + 1, nil
+}
+
+func gobPutUint(p []byte, u uint64) []byte {
+	if u < 0x80 {
+		return append(p, byte(u))
+	}
+	var b [8]byte
+	n := len(b)
+	for ; u > 0; u >>= 8 {
+		n--
+		b[n] = byte(u)
+	}
+	return append(append(p, byte(-int8(len(b)-n))), b[n:]...)
+}
+
+func gobPutInt(p []byte, i int64) []byte {
+	if i < 0 {
+		return gobPutUint(p, uint64(^i<<1)|1)
+	}
+	return gobPutUint(p, uint64(i<<1))
+}
@@ -0,0 +1,149 @@
+package fakerpc
+
+import (
+	"net"
+	"net/http"
+	"net/rpc"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Arith int
+
+func (Arith) Mul(args *Args, reply *int) error {
+	*reply = args.A * args.B
+	return nil
+}
+
+func rpcsrv(t *testing.T) string {
+	srv := rpc.NewServer()
+	if err := srv.Register(Arith(0)); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(l, srv)
+	return l.Addr().String()
+}
+
+func mulcall(t *testing.T, c *rpc.Client, a, b int) {
+	var reply int
+	if err := c.Call("Arith.Mul", &Args{A: a, B: b}, &reply); err != nil {
+		t.Errorf("expected err=nil; got %q (a=%d, b=%d)", err, a, b)
+		return
+	}
+	if reply != a*b {
+		t.Errorf("expected reply=%d; got %d", a*b, reply)
+	}
+}
+
+func TestGobCalls(t *testing.T) {
+	p, err := NewProxy("localhost:0", "http://"+rpcsrv(t))
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	go p.ListenAndServe()
+	c, err := rpc.DialHTTP("tcp", p.Addr().String())
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	mulcall(t, c, 2, 3)
+	mulcall(t, c, 4, 5)
+	c.Close()
+	log, err := p.Stop()
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	conn, err := NewConnections(log)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if len(conn) != 1 || len(conn[0]) != 1 {
+		t.Fatalf("expected single connection; got %d", len(conn))
+	}
+	calls, err := conn[0][0].GobCalls()
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	exp := []Args{{2, 3}, {4, 5}}
+	if len(calls) != len(exp) {
+		t.Fatalf("expected len(calls)=%d; got %d", len(exp), len(calls))
+	}
+	for i, call := range calls {
+		if call.ServiceMethod != "Arith.Mul" {
+			t.Errorf(`expected calls[%d].ServiceMethod="Arith.Mul"; got %q`, i, call.ServiceMethod)
+		}
+		var (
+			args  Args
+			reply int
+		)
+		if err := call.DecodeArgs(&args); err != nil {
+			t.Errorf("expected err=nil; got %q (i=%d)", err, i)
+			continue
+		}
+		if args != exp[i] {
+			t.Errorf("expected calls[%d] args=%v; got %v", i, exp[i], args)
+		}
+		if err := call.DecodeReply(&reply); err != nil {
+			t.Errorf("expected err=nil; got %q (i=%d)", err, i)
+			continue
+		}
+		if reply != exp[i].A*exp[i].B {
+			t.Errorf("expected calls[%d] reply=%d; got %d", i, exp[i].A*exp[i].B, reply)
+		}
+	}
+	srv, err := NewServer("localhost:0", log)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	var (
+		m    sync.Mutex
+		addr string
+		reqs []error
+	)
+	srv.Reply = func(src, _ net.Addr, _ int64, err error) {
+		m.Lock()
+		if src.String() != addr {
+			reqs = append(reqs, err)
+		}
+		m.Unlock()
+	}
+	done := make(chan error)
+	go func() {
+		done <- srv.ListenAndServe()
+	}()
+	m.Lock()
+	addr = srv.Addr().String()
+	m.Unlock()
+	if c, err = rpc.DialHTTP("tcp", addr); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	mulcall(t, c, 4, 5)
+	mulcall(t, c, 2, 3)
+	var reply int
+	err = c.Call("Arith.Mul", &Args{A: 2, B: 3}, &reply)
+	if err == nil || !strings.Contains(err.Error(), errNoResponse.Error()) {
+		t.Errorf("expected err=%q; got %v", errNoResponse, err)
+	}
+	c.Close()
+	if err = <-done; err != nil {
+		t.Errorf("expected err=nil; got %q", err)
+	}
+	// The CONNECT request, two matched calls and the unmatched one.
+	experr := []error{nil, nil, nil, errNoResponse}
+	if len(reqs) != len(experr) {
+		t.Fatalf("expected %d requests reported; got %v", len(experr), reqs)
+	}
+	for i, err := range reqs {
+		if err != experr[i] {
+			t.Errorf("expected err=%v; got %v (i=%d)", experr[i], err, i)
+		}
+	}
+}
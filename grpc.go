@@ -0,0 +1,83 @@
+package fakerpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+var errGRPCMessage = errors.New("fakerpc: ill-formed gRPC message")
+
+// IsGRPC reports whether the request is a gRPC call.
+func IsGRPC(req *http.Request) bool {
+	ct := req.Header.Get("Content-Type")
+	return ct == "application/grpc" || strings.HasPrefix(ct, "application/grpc+")
+}
+
+// GRPCMessages splits the body of gRPC request or response into messages.
+// Compressed messages are decoded accordingly to the given encoding, which
+// is a value of the Grpc-Encoding header.
+func GRPCMessages(body []byte, encoding string) ([][]byte, error) {
+	var msgs [][]byte
+	for len(body) != 0 {
+		if len(body) < 5 {
+			return nil, errGRPCMessage
+		}
+		compressed, n := body[0] == 1, binary.BigEndian.Uint32(body[1:5])
+		if uint32(len(body)-5) < n {
+			return nil, errGRPCMessage
+		}
+		msg := body[5 : 5+n]
+		if compressed {
+			if encoding != "gzip" {
+				return nil, errors.New("fakerpc: unsupported gRPC encoding " + encoding)
+			}
+			r, err := gzip.NewReader(bytes.NewReader(msg))
+			if err != nil {
+				return nil, err
+			}
+			if msg, err = ioutil.ReadAll(r); err != nil {
+				return nil, err
+			}
+		}
+		msgs, body = append(msgs, msg), body[5+n:]
+	}
+	return msgs, nil
+}
+
+// MatchGRPC is a Matcher, which matches gRPC calls by a full method name and
+// messages, regardless of their compression. Requests other than gRPC calls
+// are matched by MatchRequest.
+func MatchGRPC(req *http.Request, body []byte, c *Connection) bool {
+	if !IsGRPC(req) || !IsGRPC(c.Req) {
+		return MatchRequest(req, body, c)
+	}
+	if req.URL.Path != c.Req.URL.Path {
+		return false
+	}
+	x, err := GRPCMessages(body, req.Header.Get("Grpc-Encoding"))
+	if err != nil {
+		return false
+	}
+	y, err := GRPCMessages(c.ReqBody, c.Req.Header.Get("Grpc-Encoding"))
+	if err != nil || len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if !bytes.Equal(x[i], y[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchRequest is a Matcher, which matches requests by a method, URI and
+// body.
+func MatchRequest(req *http.Request, body []byte, c *Connection) bool {
+	return req.Method == c.Req.Method && req.URL.RequestURI() == c.Req.URL.RequestURI() &&
+		bytes.Equal(body, c.ReqBody)
+}
@@ -0,0 +1,148 @@
+package fakerpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func grpcframe(msg ...string) []byte {
+	var buf bytes.Buffer
+	for _, msg := range msg {
+		var prefix [5]byte
+		binary.BigEndian.PutUint32(prefix[1:], uint32(len(msg)))
+		buf.Write(prefix[:])
+		buf.WriteString(msg)
+	}
+	return buf.Bytes()
+}
+
+// grpcsrv serves a fake gRPC echo service, which replies with every message
+// read from a stream twice.
+func grpcsrv(t *testing.T) string {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		msgs, err := GRPCMessages(body, "")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.WriteHeader(http.StatusOK)
+		for _, msg := range msgs {
+			w.Write(grpcframe(string(msg), string(msg)))
+		}
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", req.URL.Path)
+	})
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go (&http.Server{Handler: h, Protocols: h2cprotos}).Serve(l)
+	return l.Addr().String()
+}
+
+func grpccall(t *testing.T, c *http.Client, addr, method string, msg ...string) {
+	req, err := http.NewRequest("POST", "http://"+addr+method, bytes.NewReader(grpcframe(msg...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	res, err := c.Do(req)
+	if err != nil {
+		t.Errorf("expected err=nil; got %q (method=%s)", err, method)
+		return
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Errorf("expected err=nil; got %q (method=%s)", err, method)
+		return
+	}
+	var exp []string
+	for _, msg := range msg {
+		exp = append(exp, msg, msg)
+	}
+	if !bytes.Equal(body, grpcframe(exp...)) {
+		t.Errorf("expected body=%q; got %q (method=%s)", grpcframe(exp...), body, method)
+	}
+	if s := res.Trailer.Get("Grpc-Status"); s != "0" {
+		t.Errorf(`expected Grpc-Status="0"; got %q (method=%s)`, s, method)
+	}
+	if s := res.Trailer.Get("Grpc-Message"); s != method {
+		t.Errorf("expected Grpc-Message=%q; got %q", method, s)
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	p, err := NewProxy("localhost:0", "http://"+grpcsrv(t))
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	go p.ListenAndServe()
+	tr := &http.Transport{Protocols: h2cprotos}
+	c := &http.Client{Transport: tr}
+	grpccall(t, c, p.Addr().String(), "/echo.Echo/Unary", "hello")
+	grpccall(t, c, p.Addr().String(), "/echo.Echo/Stream", "a", "bb", "ccc")
+	tr.CloseIdleConnections()
+	log, err := p.Stop()
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if len(log.T) != 4 {
+		t.Fatalf("expected len(log.T)=4; got %d", len(log.T))
+	}
+	for i, stream := range []uint32{1, 1, 3, 3} {
+		if log.T[i].Stream != stream {
+			t.Errorf("expected log.T[%d].Stream=%d; got %d", i, stream, log.T[i].Stream)
+		}
+	}
+	conn, err := NewConnections(log)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if len(conn) != 1 || len(conn[0]) != 2 {
+		t.Fatalf("expected single connection with 2 requests; got %v", conn)
+	}
+	if conn[0][1].Req.URL.Path != "/echo.Echo/Stream" {
+		t.Errorf(`expected path="/echo.Echo/Stream"; got %q`, conn[0][1].Req.URL.Path)
+	}
+	srv, err := NewServer("localhost:0", log)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- srv.ListenAndServe()
+	}()
+	addr := srv.Addr().String()
+	tr = &http.Transport{Protocols: h2cprotos}
+	c = &http.Client{Transport: tr}
+	grpccall(t, c, addr, "/echo.Echo/Stream", "a", "bb", "ccc")
+	grpccall(t, c, addr, "/echo.Echo/Unary", "hello")
+	res, err := c.Post("http://"+addr+"/echo.Echo/Unary", "application/grpc",
+		bytes.NewReader(grpcframe("hello")))
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected res.StatusCode=500; got %d", res.StatusCode)
+	}
+	tr.CloseIdleConnections()
+	if err = <-done; err != nil {
+		t.Errorf("expected err=nil; got %q", err)
+	}
+}
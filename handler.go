@@ -0,0 +1,155 @@
+package fakerpc
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// A replyHandler replies to HTTP requests with the recorded responses of the
+// calls looked up with the match; each call is replied once.
+type replyHandler struct {
+	srv   *Server
+	c     []Connection
+	match Matcher  // srv.Match or MatchRequest is used if nil
+	src   net.Addr // local address of the request is used if nil
+	rem   net.Addr // remote address of the request is used if nil
+	m     sync.Mutex
+	used  []bool
+}
+
+func newReplyHandler(srv *Server, c []Connection) *replyHandler {
+	return &replyHandler{srv: srv, c: c, used: make([]bool, len(c))}
+}
+
+// Handler gives a http.Handler replying with the recorded responses, which
+// makes it possible to mount the fake inside a httptest.Server or an existing
+// http.ServeMux. Since a handler does not see the connections the requests came
+// over, each request is looked up among calls of all the recorded connections
+// with srv.Match, or with MatchRequest when the former is nil. Responses
+// turning the connection into a tunnel are replied by hijacking it.
+//
+// The srv does not need to be running for the handler to work.
+func (srv *Server) Handler() http.Handler {
+	var c []Connection
+	for _, conn := range srv.conn {
+		c = append(c, conn...)
+	}
+	return newReplyHandler(srv, c)
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *replyHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	src, rem := h.src, h.rem
+	if src == nil {
+		src, _ = req.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	}
+	if rem == nil {
+		rem = parseaddr(req.RemoteAddr)
+	}
+	var body bytes.Buffer
+	n, err := io.Copy(&body, req.Body)
+	j := h.lookup(req, body.Bytes())
+	if j == -1 || h.c[j].Res == nil {
+		http.Error(w, errNoResponse.Error(), http.StatusInternalServerError)
+		h.srv.Reply(rem, src, n, errNoResponse)
+		return
+	}
+	h.srv.Reply(rem, src, n, err)
+	if status := h.srv.inject(); status != 0 {
+		h.srv.Reply(src, rem, 0, errFault)
+		if status == -1 {
+			panic(http.ErrAbortHandler)
+		}
+		http.Error(w, errFault.Error(), status)
+		return
+	}
+	if h.c[j].Tunnel != nil && (req.Method == "CONNECT" || IsWebSocket(req)) {
+		h.tunnel(w, req, &h.c[j], src, rem)
+		return
+	}
+	n, err = writeResponse(w, h.c[j].Res, req)
+	h.srv.Reply(src, rem, n, err)
+}
+
+// lookup gives an index of the first call not replied yet, which matches
+// the req, or -1 if there's none.
+func (h *replyHandler) lookup(req *http.Request, body []byte) int {
+	match := h.match
+	if match == nil {
+		if match = h.srv.Match; match == nil {
+			match = MatchRequest
+		}
+	}
+	h.m.Lock()
+	defer h.m.Unlock()
+	for i := range h.c {
+		if !h.used[i] && match(req, body, &h.c[i]) {
+			h.used[i] = true
+			return i
+		}
+	}
+	return -1
+}
+
+// tunnel hijacks the connection of the req, writes the raw response of the c
+// and replies with the recorded tunnel traffic.
+func (h *replyHandler) tunnel(w http.ResponseWriter, req *http.Request, c *Connection, src, rem net.Addr) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, errNoHijack.Error(), http.StatusInternalServerError)
+		h.srv.Reply(src, rem, 0, errNoHijack)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		h.srv.Reply(src, rem, 0, err)
+		return
+	}
+	defer conn.Close()
+	res := c.Res
+	if c.Req.ProtoMajor == 2 {
+		res = downgrade(res, req)
+	}
+	if IsWebSocket(req) {
+		res = wshandshake(res, req)
+	}
+	_, err = conn.Write(res)
+	h.srv.Reply(src, rem, int64(len(res)), err)
+	if err != nil {
+		return
+	}
+	if req.Method == "CONNECT" {
+		var calls []GobCall
+		if calls, err = c.GobCalls(); err == nil {
+			err = h.srv.serveGob(conn, rw.Reader, calls, rem)
+		}
+	} else {
+		var frames []Frame
+		if frames, err = c.Frames(); err == nil {
+			err = h.srv.serveWebSocket(conn, rw.Reader, frames, rem)
+		}
+	}
+	if err != nil && err != io.EOF {
+		h.srv.Reply(rem, src, 0, err)
+	}
+}
+
+// parseaddr gives the "ip:port" address s, or nil for a "host:port" one, as
+// unlike tcpaddr it never resolves host names. Requests served over Unix domain
+// sockets have remote addresses like "@" instead, which are given as Unix ones.
+func parseaddr(s string) net.Addr {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return &net.UnixAddr{Name: s, Net: "unix"}
+	}
+	ip := net.ParseIP(host)
+	n, err := strconv.Atoi(port)
+	if ip == nil || err != nil {
+		return nil
+	}
+	return &net.TCPAddr{IP: ip, Port: n}
+}
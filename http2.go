@@ -0,0 +1,382 @@
+package fakerpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// h2preface is a beginning of the HTTP/2 client connection preface.
+var h2preface = []byte("PRI * HTTP/2.0")
+
+var h2protos = func() *http.Protocols {
+	p := &http.Protocols{}
+	p.SetHTTP1(true)
+	p.SetHTTP2(true)
+	p.SetUnencryptedHTTP2(true)
+	return p
+}()
+
+var h2cprotos = func() *http.Protocols {
+	p := &http.Protocols{}
+	p.SetUnencryptedHTTP2(true)
+	return p
+}()
+
+// tlsconfig gives a copy of the cfg, which negotiates HTTP/2 via ALPN.
+func tlsconfig(cfg *tls.Config) *tls.Config {
+	cfg = cfg.Clone()
+	if len(cfg.NextProtos) == 0 {
+		cfg.NextProtos = []string{"h2", "http/1.1"}
+	}
+	return cfg
+}
+
+// dumpRequest gives raw HTTP/1.x-formatted request with the given body.
+func dumpRequest(req *http.Request, body []byte) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s HTTP/%d.%d\r\nHost: %s\r\n", req.Method, req.URL.RequestURI(),
+		req.ProtoMajor, req.ProtoMinor, req.Host)
+	h := req.Header.Clone()
+	h.Del("Transfer-Encoding")
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	h.Write(&buf)
+	buf.WriteString("\r\n")
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// dumpResponse gives raw HTTP/1.x-formatted response with the given body and
+// trailers. Response with trailers uses chunked transfer encoding.
+func dumpResponse(major, minor, code int, header http.Header, body []byte, trailer http.Header) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "HTTP/%d.%d %03d %s\r\n", major, minor, code, http.StatusText(code))
+	h := header.Clone()
+	h.Del("Transfer-Encoding")
+	h.Del("Trailer")
+	if len(trailer) == 0 {
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+		h.Write(&buf)
+		buf.WriteString("\r\n")
+		buf.Write(body)
+		return buf.Bytes()
+	}
+	keys := make([]string, 0, len(trailer))
+	for k := range trailer {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h.Del("Content-Length")
+	h.Set("Trailer", strings.Join(keys, ", "))
+	h.Set("Transfer-Encoding", "chunked")
+	h.Write(&buf)
+	buf.WriteString("\r\n")
+	if len(body) != 0 {
+		fmt.Fprintf(&buf, "%x\r\n", len(body))
+		buf.Write(body)
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("0\r\n")
+	trailer.Write(&buf)
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+type connKey struct{}
+
+func withConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// recConnOf gives a recording connection, which the req was read from.
+func recConnOf(req *http.Request) *recConn {
+	c, _ := req.Context().Value(connKey{}).(net.Conn)
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	rc, _ := c.(*recConn)
+	return rc
+}
+
+// A lockedbuf is a bytes.Buffer safe for concurrent use.
+type lockedbuf struct {
+	m   sync.Mutex
+	buf bytes.Buffer
+}
+
+func (lb *lockedbuf) Write(p []byte) (int, error) {
+	lb.m.Lock()
+	defer lb.m.Unlock()
+	return lb.buf.Write(p)
+}
+
+func (lb *lockedbuf) Bytes() []byte {
+	lb.m.Lock()
+	defer lb.m.Unlock()
+	return append([]byte(nil), lb.buf.Bytes()...)
+}
+
+// A recWriter records a response written by a handler.
+type recWriter struct {
+	http.ResponseWriter
+	code   int
+	header http.Header
+	body   lockedbuf
+	time   time.Time
+}
+
+func (rw *recWriter) WriteHeader(code int) {
+	if rw.header == nil {
+		rw.code, rw.header = code, rw.ResponseWriter.Header().Clone()
+		rw.time = time.Now()
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recWriter) Write(p []byte) (int, error) {
+	if rw.header == nil {
+		rw.WriteHeader(http.StatusOK)
+	}
+	rw.body.Write(p)
+	return rw.ResponseWriter.Write(p)
+}
+
+func (rw *recWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rw *recWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// trailer gives trailers set by a handler after the body was written.
+func (rw *recWriter) trailer() http.Header {
+	t := make(http.Header)
+	for _, v := range rw.header["Trailer"] {
+		for _, k := range strings.Split(v, ",") {
+			if k = http.CanonicalHeaderKey(strings.TrimSpace(k)); k != "" {
+				if v, ok := rw.ResponseWriter.Header()[k]; ok {
+					t[k] = v
+				}
+			}
+		}
+	}
+	for k, v := range rw.ResponseWriter.Header() {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			t[http.CanonicalHeaderKey(strings.TrimPrefix(k, http.TrailerPrefix))] = v
+		}
+	}
+	return t
+}
+
+// serveRecord passes the req to the h, recording the request and the response
+// as a pair of transmissions.
+func (rc *recConn) serveRecord(h http.Handler, rw http.ResponseWriter, req *http.Request) {
+	var (
+		body  lockedbuf
+		rec   = &recWriter{ResponseWriter: rw}
+		start = time.Now()
+	)
+	if req.Body != nil {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(req.Body, &body), req.Body}
+	}
+	h.ServeHTTP(rec, req)
+	if rec.header == nil {
+		rec.code, rec.header, rec.time = http.StatusOK, rw.Header().Clone(), time.Now()
+	}
+	var stream uint32
+	if req.ProtoMajor == 2 {
+		stream = rc.nextStream()
+	}
+	rc.recordExchange(Transmission{
+		Src:    rc.dst,
+		Dst:    rc.src,
+		Raw:    dumpRequest(req, body.Bytes()),
+		Stream: stream,
+		Time:   start,
+	}, Transmission{
+		Src:    rc.src,
+		Dst:    rc.dst,
+		Raw:    dumpResponse(req.ProtoMajor, req.ProtoMinor, rec.code, rec.header, rec.body.Bytes(), rec.trailer()),
+		Stream: stream,
+		Time:   rec.time,
+	})
+}
+
+// A bufConn is a net.Conn, which reads from a buffered reader.
+type bufConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (bc bufConn) Read(p []byte) (int, error) {
+	return bc.r.Read(p)
+}
+
+// A connListener is a net.Listener, which accepts the single connection.
+type connListener struct {
+	c    chan net.Conn
+	addr net.Addr
+	onc  sync.Once
+	done chan struct{}
+}
+
+func newConnListener(c net.Conn) *connListener {
+	l := &connListener{
+		c:    make(chan net.Conn, 1),
+		addr: c.LocalAddr(),
+		done: make(chan struct{}),
+	}
+	l.c <- c
+	return l
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.c:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.onc.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
+
+// isHTTP2 reports whether the rw connection speaks HTTP/2, either negotiated
+// via ALPN or with a prior knowledge.
+func isHTTP2(rw net.Conn, r *bufio.Reader) (bool, error) {
+	if tc, ok := rw.(*tls.Conn); ok {
+		if err := tc.Handshake(); err != nil {
+			return false, err
+		}
+		return tc.ConnectionState().NegotiatedProtocol == "h2", nil
+	}
+	p, err := r.Peek(len(h2preface))
+	if err != nil && len(p) == 0 {
+		return false, err
+	}
+	return bytes.Equal(p, h2preface), nil
+}
+
+// serveHTTP2 replies to HTTP/2 streams read from the rw connection with the
+// recorded responses, either recorded over HTTP/2 or HTTP/1.x. Since streams
+// are multiplexed, responses are looked up with srv.Match or with MatchGRPC
+// when the former is nil.
+func (srv *Server) serveHTTP2(rw net.Conn, r *bufio.Reader, c []Connection, rem net.Addr) error {
+	l := newConnListener(bufConn{Conn: rw, r: r})
+	h := newReplyHandler(srv, c)
+	if h.match = srv.Match; h.match == nil {
+		h.match = MatchGRPC
+	}
+	h.src, h.rem = srv.src, rem
+	hs := &http.Server{
+		Handler:   h,
+		Protocols: h2cprotos,
+		ConnState: func(_ net.Conn, st http.ConnState) {
+			if st == http.StateClosed || st == http.StateHijacked {
+				l.Close()
+			}
+		},
+	}
+	// The hs is kept by the srv, so that Shutdown can tell the client to go
+	// away even if the connection is idle.
+	srv.m.Lock()
+	if atomic.LoadUint32(&srv.closing) == 1 {
+		srv.m.Unlock()
+		return nil
+	}
+	if srv.h2 == nil {
+		srv.h2 = make(map[*http.Server]struct{})
+	}
+	srv.h2[hs] = struct{}{}
+	srv.m.Unlock()
+	defer func() {
+		srv.m.Lock()
+		delete(srv.h2, hs)
+		srv.m.Unlock()
+	}()
+	if err := hs.Serve(l); !errors.Is(err, net.ErrClosed) && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// hopbyhop lists connection-specific headers, which are not allowed in HTTP/2
+// responses.
+var hopbyhop = map[string]bool{
+	"Connection":        true,
+	"Keep-Alive":        true,
+	"Proxy-Connection":  true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+// downgrade converts raw response recorded over HTTP/2 into a HTTP/1.x one,
+// understood by a client which sent the req. Trailers are dropped for HTTP/1.0
+// clients.
+func downgrade(res []byte, req *http.Request) []byte {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(res)), req)
+	if err != nil {
+		return res
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return res
+	}
+	trailer := resp.Trailer
+	if !req.ProtoAtLeast(1, 1) {
+		trailer = nil
+	}
+	return dumpResponse(req.ProtoMajor, req.ProtoMinor, resp.StatusCode, resp.Header, body, trailer)
+}
+
+// writeResponse writes the raw response res to the w, including trailers.
+func writeResponse(w http.ResponseWriter, res []byte, req *http.Request) (int64, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(res)), req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return 0, err
+	}
+	defer resp.Body.Close()
+	h := w.Header()
+	for k, v := range resp.Header {
+		if !hopbyhop[k] {
+			h[k] = v
+		}
+	}
+	for k := range resp.Trailer {
+		h.Add("Trailer", k)
+	}
+	w.WriteHeader(resp.StatusCode)
+	n, err := io.Copy(w, resp.Body)
+	for k, v := range resp.Trailer {
+		h[k] = v
+	}
+	return n, err
+}
@@ -0,0 +1,267 @@
+package fakerpc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// h2srv serves an echo service over HTTP/2 with a prior knowledge, which
+// sends a length of the request body in a trailer.
+func h2srv(t *testing.T) string {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor != 2 {
+			t.Errorf("expected req.ProtoMajor=2; got %d", req.ProtoMajor)
+		}
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		w.Header().Set("Trailer", "X-Length")
+		w.Write(body)
+		w.Header().Set("X-Length", strings.Repeat("*", len(body)))
+	})
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go (&http.Server{Handler: h, Protocols: h2cprotos}).Serve(l)
+	return l.Addr().String()
+}
+
+func h2post(t *testing.T, c *http.Client, url, body string) {
+	res, err := c.Post(url, "text/plain", strings.NewReader(body))
+	if err != nil {
+		t.Errorf("expected err=nil; got %q (body=%q)", err, body)
+		return
+	}
+	defer res.Body.Close()
+	p, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Errorf("expected err=nil; got %q (body=%q)", err, body)
+		return
+	}
+	if string(p) != body {
+		t.Errorf("expected res.Body=%q; got %q", body, p)
+	}
+	if s := res.Trailer.Get("X-Length"); s != strings.Repeat("*", len(body)) {
+		t.Errorf("expected X-Length=%q; got %q", strings.Repeat("*", len(body)), s)
+	}
+}
+
+func TestHTTP2(t *testing.T) {
+	p, err := NewProxy("localhost:0", "h2c://"+h2srv(t))
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	go p.ListenAndServe()
+	tr := &http.Transport{Protocols: h2cprotos}
+	c := &http.Client{Transport: tr}
+	url := "http://" + p.Addr().String()
+	h2post(t, c, url+"/a", "first")
+	h2post(t, c, url+"/b", "second")
+	tr.CloseIdleConnections()
+	log, err := p.Stop()
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	srv, err := NewServer("localhost:0", log)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- srv.ListenAndServe()
+	}()
+	url = "http://" + srv.Addr().String()
+	tr = &http.Transport{}
+	c = &http.Client{Transport: tr}
+	h2post(t, c, url+"/b", "second")
+	h2post(t, c, url+"/a", "first")
+	tr.CloseIdleConnections()
+	if err = <-done; err != nil {
+		t.Errorf("expected err=nil; got %q", err)
+	}
+}
+
+func TestHTTP2TLS(t *testing.T) {
+	// The httptest server provides a certificate trusted by its client.
+	ts := httptest.NewUnstartedServer(http.NotFoundHandler())
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+	c := ts.Client()
+	defer c.Transport.(*http.Transport).CloseIdleConnections()
+	p, err := NewProxy("localhost:0", "h2c://"+h2srv(t))
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	p.TLSConfig = ts.TLS
+	go p.ListenAndServe()
+	url := "https://" + p.Addr().String()
+	res, err := c.Get(url + "/a")
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	res.Body.Close()
+	if res.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2 negotiated with the Proxy; got %s", res.Proto)
+	}
+	h2post(t, c, url+"/b", "second")
+	c.Transport.(*http.Transport).CloseIdleConnections()
+	log, err := p.Stop()
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if len(log.T) != 4 || log.T[0].Stream == 0 {
+		t.Fatalf("expected 4 transmissions of HTTP/2 streams; got %v", log.T)
+	}
+	srv, err := NewServer("localhost:0", log)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	srv.TLSConfig = ts.TLS
+	done := make(chan error)
+	go func() {
+		done <- srv.ListenAndServe()
+	}()
+	url = "https://" + srv.Addr().String()
+	h2post(t, c, url+"/b", "second")
+	if res, err = c.Get(url + "/a"); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	res.Body.Close()
+	if res.ProtoMajor != 2 || res.StatusCode != 200 {
+		t.Errorf("expected 200 response over HTTP/2 from the Server; got %s %s", res.Proto, res.Status)
+	}
+	c.Transport.(*http.Transport).CloseIdleConnections()
+	if err = <-done; err != nil {
+		t.Errorf("expected err=nil; got %q", err)
+	}
+}
+
+func TestProxyTLSConnect(t *testing.T) {
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+	p, err := NewProxy("localhost:0", "http://127.0.0.1:1")
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	p.TLSConfig = ts.TLS
+	go p.ListenAndServe()
+	defer p.Stop()
+	cfg := ts.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	cfg.NextProtos = []string{"http/1.1"}
+	c, err := tls.Dial("tcp", p.Addr().String(), cfg)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	defer c.Close()
+	if _, err = io.WriteString(c, "CONNECT /_goRPC_ HTTP/1.0\r\n\r\n"); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	res, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotImplemented {
+		t.Errorf("expected status=%d; got %d", http.StatusNotImplemented, res.StatusCode)
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	cases := [...]struct {
+		protos []string
+		exp    []string
+	}{
+		{nil, []string{"h2", "http/1.1"}},
+		{[]string{"http/1.1"}, []string{"http/1.1"}},
+	}
+	for i, cas := range cases {
+		cfg := &tls.Config{NextProtos: cas.protos}
+		if got := tlsconfig(cfg).NextProtos; !equalStrings(got, cas.exp) {
+			t.Errorf("expected NextProtos=%v; got %v (i=%d)", cas.exp, got, i)
+		}
+		if !equalStrings(cfg.NextProtos, cas.protos) {
+			t.Errorf("expected the cfg to be left intact; got %v (i=%d)", cfg.NextProtos, i)
+		}
+	}
+}
+
+func TestNewConnectionsStreams(t *testing.T) {
+	req := func(path string) []byte {
+		return []byte("GET " + path + " HTTP/2.0\r\nHost: localhost\r\n\r\n")
+	}
+	res := func(body string) []byte {
+		return []byte("HTTP/2.0 200 OK\r\nContent-Length: 1\r\n\r\n" + body)
+	}
+	log := &Log{T: []Transmission{
+		{Src: &cli[0], Dst: srv, Stream: 1, Raw: req("/1")},
+		{Src: &cli[0], Dst: srv, Stream: 3, Raw: req("/3")},
+		{Src: srv, Dst: &cli[0], Stream: 3, Raw: res("3")},
+		{Src: &cli[0], Dst: srv, Stream: 5, Raw: req("/5")},
+		{Src: srv, Dst: &cli[0], Stream: 1, Raw: res("1")},
+		{Src: srv, Dst: &cli[0], Stream: 5, Raw: res("5")},
+	}}
+	conn, err := NewConnections(log)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if len(conn) != 1 || len(conn[0]) != 3 {
+		t.Fatalf("expected single connection with 3 requests; got %v", conn)
+	}
+	for i, c := range conn[0] {
+		if p := c.Req.URL.Path; !bytes.HasSuffix(c.Res, []byte(p[1:])) {
+			t.Errorf("expected conn[0][%d].Res to end with %q; got %q", i, p[1:], c.Res)
+		}
+	}
+}
+
+func TestProxySink(t *testing.T) {
+	var buf lockedbuf
+	p, err := NewProxy("localhost:0", "h2c://"+h2srv(t))
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	p.Sink = NewLogWriter(&buf)
+	go p.ListenAndServe()
+	tr := &http.Transport{Protocols: h2cprotos}
+	c := &http.Client{Transport: tr}
+	url := "http://" + p.Addr().String()
+	h2post(t, c, url+"/a", "first")
+	tr.CloseIdleConnections()
+	h2post(t, c, url+"/b", "second")
+	tr.CloseIdleConnections()
+	log, err := p.Stop()
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if len(log.T) != 0 {
+		t.Errorf("expected len(log.T)=0; got %d", len(log.T))
+	}
+	lr, err := NewLogReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if lr.Filter == "" {
+		t.Error("expected lr.Filter to be non-empty")
+	}
+	for i, path := range []string{"/a", "/b"} {
+		chunk, err := lr.Next()
+		if err != nil {
+			t.Fatalf("expected err=nil; got %q (i=%d)", err, i)
+		}
+		if len(chunk) != 2 || !bytes.HasPrefix(chunk[0].Raw, []byte("POST "+path)) {
+			t.Errorf("expected request for %s; got %q (i=%d)", path, chunk, i)
+		}
+	}
+}
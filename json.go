@@ -0,0 +1,98 @@
+package fakerpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"time"
+	"unicode/utf8"
+)
+
+// A jsonLog is a JSON representation of the Log.
+type jsonLog struct {
+	Networks []string           `json:"networks,omitempty"`
+	Filter   string             `json:"filter,omitempty"`
+	T        []jsonTransmission `json:"transmissions"`
+}
+
+// A jsonTransmission is a JSON representation of the Transmission. Raw holds
+// the data if it's a valid UTF-8 text, Raw64 - base64-encoded otherwise.
+type jsonTransmission struct {
+	Src    string     `json:"src"`
+	Dst    string     `json:"dst"`
+	Raw    *string    `json:"raw,omitempty"`
+	Raw64  []byte     `json:"raw64,omitempty"`
+	Stream uint32     `json:"stream,omitempty"`
+	Time   *time.Time `json:"time,omitempty"`
+}
+
+// JSONMarshal writes to w the l encoded as a JSON document, which is meant
+// to be read and edited by humans and other tools.
+func JSONMarshal(w io.Writer, l *Log) error {
+	v := jsonLog{T: make([]jsonTransmission, 0, len(l.T))}
+	for _, n := range l.Networks {
+		v.Networks = append(v.Networks, n.String())
+	}
+	v.Filter = l.Filter
+	for _, t := range l.T {
+		jt := jsonTransmission{Stream: t.Stream}
+		if t.Src != nil {
+			jt.Src = addrstring(t.Src)
+		}
+		if t.Dst != nil {
+			jt.Dst = addrstring(t.Dst)
+		}
+		if utf8.Valid(t.Raw) {
+			s := string(t.Raw)
+			jt.Raw = &s
+		} else {
+			jt.Raw64 = t.Raw
+		}
+		if !t.Time.IsZero() {
+			tm := t.Time
+			jt.Time = &tm
+		}
+		v.T = append(v.T, jt)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(v)
+}
+
+// JSONUnmarshal parses the JSON document written by JSONMarshal read from r
+// and stores the result in the l.
+func JSONUnmarshal(r io.Reader, l *Log) error {
+	var v jsonLog
+	if err := json.NewDecoder(r).Decode(&v); err != nil {
+		return err
+	}
+	for _, s := range v.Networks {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return err
+		}
+		l.Networks = append(l.Networks, n)
+	}
+	l.Filter = v.Filter
+	for i, jt := range v.T {
+		t := Transmission{Stream: jt.Stream}
+		var err error
+		if t.Src, err = parsenetaddr(jt.Src); err != nil {
+			return fmt.Errorf("fakerpc: transmissions[%d]: %v", i, err)
+		}
+		if t.Dst, err = parsenetaddr(jt.Dst); err != nil {
+			return fmt.Errorf("fakerpc: transmissions[%d]: %v", i, err)
+		}
+		if jt.Raw != nil {
+			t.Raw = []byte(*jt.Raw)
+		} else {
+			t.Raw = jt.Raw64
+		}
+		if jt.Time != nil {
+			t.Time = *jt.Time
+		}
+		l.T = append(l.T, t)
+	}
+	return nil
+}
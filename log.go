@@ -0,0 +1,319 @@
+package fakerpc
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An exchange holds indices of transmissions, which belong to a single request:
+// the request itself, its response or -1 if there is none and transmissions
+// tunneled after the response.
+type exchange struct {
+	req, res int
+	tunnel   []int
+}
+
+// exchanges splits transmissions of the log into exchanges, ordered by their
+// requests.
+func exchanges(log *Log) []exchange {
+	var ex []exchange
+	skip := make(map[int]struct{})
+	for i := range log.T {
+		if _, ok := skip[i]; ok {
+			continue
+		}
+		e := exchange{req: i, res: -1}
+		if !bytes.HasPrefix(log.T[i].Raw, []byte("HTTP/")) {
+			// A response without request is an exchange on its own.
+			e.res = response(log, i, skip)
+		}
+		if e.res != -1 {
+			skip[e.res] = struct{}{}
+			if istunnel(log.T[i].Raw, log.T[e.res].Raw) {
+				client := log.T[e.res].Dst
+				for k := e.res + 1; k < len(log.T); k++ {
+					if addrequal(log.T[k].Src, client) || addrequal(log.T[k].Dst, client) {
+						e.tunnel = append(e.tunnel, k)
+						skip[k] = struct{}{}
+					}
+				}
+			}
+		}
+		ex = append(ex, e)
+	}
+	return ex
+}
+
+// istunnel reports whether the connection turns into a tunnel after the raw
+// request and response, either due to CONNECT method or protocol upgrade.
+func istunnel(req, res []byte) bool {
+	code := statusCode(res)
+	return code == 101 || (bytes.HasPrefix(req, []byte("CONNECT ")) && code/100 == 2)
+}
+
+func (e exchange) indices() []int {
+	idx := []int{e.req}
+	if e.res != -1 {
+		idx = append(idx, e.res)
+	}
+	return append(idx, e.tunnel...)
+}
+
+// sub gives a log with the l's transmissions under the given indices, keeping
+// their original order.
+func (l *Log) sub(idx []int) *Log {
+	sort.Ints(idx)
+	sl := &Log{Networks: l.Networks, Filter: l.Filter, T: make([]Transmission, 0, len(idx))}
+	for _, i := range idx {
+		sl.T = append(sl.T, l.T[i])
+	}
+	return sl
+}
+
+// pick gives a log with transmissions of the l's exchanges, for which keep
+// returns true. The keep is called with a number of the exchange, counting in
+// order requests were sent.
+func (l *Log) pick(keep func(n int, e exchange) bool) *Log {
+	var idx []int
+	for n, e := range exchanges(l) {
+		if keep(n, e) {
+			idx = append(idx, e.indices()...)
+		}
+	}
+	return l.sub(idx)
+}
+
+// Conns splits the l into logs, each holding transmissions of a single TCP
+// connection. The logs are ordered by the first request sent over
+// the connection.
+func (l *Log) Conns() []*Log {
+	var (
+		idx   [][]int
+		index = make(map[string]int)
+	)
+	for _, e := range exchanges(l) {
+		addr := l.T[e.req].Src.String()
+		n, ok := index[addr]
+		if !ok {
+			idx = append(idx, nil)
+			n = len(idx) - 1
+			index[addr] = n
+		}
+		idx[n] = append(idx[n], e.indices()...)
+	}
+	conns := make([]*Log, 0, len(idx))
+	for _, idx := range idx {
+		conns = append(conns, l.sub(idx))
+	}
+	return conns
+}
+
+// WhereConn gives a log with transmissions of the l's TCP connections, for which
+// f returns true. The f is called with a log of a single connection.
+func (l *Log) WhereConn(f func(conn *Log) bool) *Log {
+	keep := make(map[string]bool)
+	for _, conn := range l.Conns() {
+		keep[conn.T[0].Src.String()] = f(conn)
+	}
+	return l.pick(func(_ int, e exchange) bool {
+		return keep[l.T[e.req].Src.String()]
+	})
+}
+
+// A Predicate reports whether a request should be kept.
+type Predicate func(req *http.Request) bool
+
+// Where gives a log with transmissions of the l's requests, for which f returns
+// true, along with their responses and tunnels. Requests, which cannot be
+// parsed, are dropped.
+func (l *Log) Where(f Predicate) *Log {
+	return l.pick(func(_ int, e exchange) bool {
+		header, _ := SplitHeaderBody(l.T[e.req].Raw)
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(header)))
+		return err == nil && f(req)
+	})
+}
+
+// ByPath gives a Predicate, which matches requests by their URL path, using
+// a pattern syntax of path.Match.
+func ByPath(pattern string) Predicate {
+	return func(req *http.Request) bool {
+		ok, _ := path.Match(pattern, req.URL.Path)
+		return ok
+	}
+}
+
+// ByMethod gives a Predicate, which matches requests by any of the methods.
+func ByMethod(method ...string) Predicate {
+	return func(req *http.Request) bool {
+		for _, method := range method {
+			if strings.EqualFold(req.Method, method) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ByHost gives a Predicate, which matches requests by their Host header. A host
+// given without a port matches any port.
+func ByHost(host string) Predicate {
+	return func(req *http.Request) bool {
+		if strings.EqualFold(req.Host, host) {
+			return true
+		}
+		h, _, err := net.SplitHostPort(req.Host)
+		return err == nil && strings.EqualFold(h, host)
+	}
+}
+
+// Not gives a Predicate, which negates the f.
+func Not(f Predicate) Predicate {
+	return func(req *http.Request) bool {
+		return !f(req)
+	}
+}
+
+// Slice gives a log with the l's requests i through j-1, counting in order they
+// were sent, along with their responses and tunnels. Out of range indices are
+// clamped.
+func (l *Log) Slice(i, j int) *Log {
+	return l.pick(func(n int, _ exchange) bool {
+		return n >= i && n < j
+	})
+}
+
+// Between gives a log with the l's requests sent within [from, to) time range,
+// along with their responses and tunnels. A zero from or to leaves the range
+// unbounded on that side. Requests with unknown time are dropped.
+func (l *Log) Between(from, to time.Time) *Log {
+	return l.pick(func(_ int, e exchange) bool {
+		t := l.T[e.req].Time
+		return !t.IsZero() && (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+	})
+}
+
+// Map gives a log with every l's transmission rewritten by f. The f must not
+// change direction of a transmission, otherwise requests get paired with wrong
+// responses.
+func (l *Log) Map(f func(t Transmission) Transmission) *Log {
+	ml := &Log{Networks: l.Networks, Filter: l.Filter, T: make([]Transmission, 0, len(l.T))}
+	for _, t := range l.T {
+		ml.T = append(ml.T, f(t))
+	}
+	return ml
+}
+
+// MergeLogs concatenates the logs into a single one. A client address, which
+// was already used by a preceding log, gets remapped to a free port of the same
+// IP, or a free number of a Unix domain client, so connections of different
+// logs are not mixed up. Networks are merged
+// and distinct filters are joined with "or".
+func MergeLogs(logs ...*Log) *Log {
+	var (
+		ml      = NewLog()
+		used    = make(map[string]struct{})
+		nets    = make(map[string]struct{})
+		filters []string
+	)
+	for _, l := range logs {
+		if l == nil {
+			continue
+		}
+		for _, network := range l.Networks {
+			if _, ok := nets[network.String()]; !ok {
+				nets[network.String()] = struct{}{}
+				ml.Networks = append(ml.Networks, network)
+			}
+		}
+		if l.Filter != "" && !hasString(filters, l.Filter) {
+			filters = append(filters, l.Filter)
+		}
+		var (
+			ex     = exchanges(l)
+			own    = make(map[string]struct{})
+			remap  = make(map[string]net.Addr)
+			mapped = func(addr net.Addr) net.Addr {
+				if addr == nil {
+					return nil
+				}
+				if a, ok := remap[addr.String()]; ok {
+					return a
+				}
+				return addr
+			}
+		)
+		for _, e := range ex {
+			own[l.T[e.req].Src.String()] = struct{}{}
+		}
+		for _, e := range ex {
+			client := l.T[e.req].Src
+			if _, ok := remap[client.String()]; ok {
+				continue
+			}
+			addr := client
+			if _, ok := used[addr.String()]; ok {
+				addr = freeport(client, used, own)
+			}
+			used[addr.String()] = struct{}{}
+			remap[client.String()] = addr
+		}
+		for _, t := range l.T {
+			t.Src, t.Dst = mapped(t.Src), mapped(t.Dst)
+			ml.T = append(ml.T, t)
+		}
+	}
+	switch len(filters) {
+	case 0:
+	case 1:
+		ml.Filter = filters[0]
+	default:
+		ml.Filter = "(" + strings.Join(filters, ") or (") + ")"
+	}
+	return ml
+}
+
+// freeport gives an address with the addr's IP and the next port, which is
+// neither used nor own. A numbered client address of a Unix domain connection
+// gets the next number instead. It gives the addr if there are no ports left
+// or it's a named Unix address.
+func freeport(addr net.Addr, used, own map[string]struct{}) net.Addr {
+	free := func(a net.Addr) bool {
+		_, u := used[a.String()]
+		_, o := own[a.String()]
+		return !u && !o
+	}
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		for port := addr.Port%65535 + 1; port != addr.Port; port = port%65535 + 1 {
+			if a := (&net.TCPAddr{IP: addr.IP, Port: port, Zone: addr.Zone}); free(a) {
+				return a
+			}
+		}
+	case *net.UnixAddr:
+		if !strings.HasPrefix(addr.Name, "@") {
+			break
+		}
+		for n := uint32(1); n != 0; n++ {
+			if a := unixpeer(n); free(a) {
+				return a
+			}
+		}
+	}
+	return addr
+}
+
+func hasString(s []string, v string) bool {
+	for _, u := range s {
+		if u == v {
+			return true
+		}
+	}
+	return false
+}
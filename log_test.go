@@ -0,0 +1,119 @@
+package fakerpc
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func paths(t *testing.T, l *Log) (p []string) {
+	conn, err := NewConnections(l)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	for _, conn := range conn {
+		for _, c := range conn {
+			p = append(p, c.Req.URL.Path)
+		}
+	}
+	return p
+}
+
+func equalStrings(lhs, rhs []string) bool {
+	if len(lhs) != len(rhs) {
+		return false
+	}
+	for i := range lhs {
+		if lhs[i] != rhs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLogConns(t *testing.T) {
+	conns := log.Conns()
+	if len(conns) != len(expconn) {
+		t.Fatalf("expected len(conns)=%d; got %d", len(expconn), len(conns))
+	}
+	for i, conn := range conns {
+		if n := 2 * len(expconn[i]); len(conn.T) != n {
+			t.Errorf("expected len(conns[%d].T)=%d; got %d", i, n, len(conn.T))
+		}
+	}
+}
+
+func TestLogWhere(t *testing.T) {
+	cases := [...]struct {
+		l   *Log
+		exp []string
+	}{{
+		log.Where(ByPath("/[12]")), []string{"/1", "/2"},
+	}, {
+		log.Where(Not(ByPath("/[12]"))), []string{"/3", "/4", "/5"},
+	}, {
+		log.Where(ByMethod("post")).Where(ByPath("/4")), []string{"/4"},
+	}, {
+		log.WhereConn(func(conn *Log) bool { return len(conn.T) == 4 }), []string{"/1", "/2", "/4", "/5"},
+	}, {
+		log.Slice(1, 4), []string{"/2", "/3", "/4"},
+	}, {
+		log.Slice(-1, 100), []string{"/1", "/2", "/3", "/4", "/5"},
+	}}
+	for i, cas := range cases {
+		if p := paths(t, cas.l); !equalStrings(p, cas.exp) {
+			t.Errorf("expected paths=%v; got %v (i=%d)", cas.exp, p, i)
+		}
+	}
+	if l := log.Where(ByMethod("GET")); len(l.T) != 0 {
+		t.Errorf("expected len(l.T)=0; got %d", len(l.T))
+	}
+	req := &http.Request{Host: "example.com:8080"}
+	if !ByHost("example.com")(req) || !ByHost("example.com:8080")(req) || ByHost("example.org")(req) {
+		t.Error("expected ByHost to match a host regardless of the port")
+	}
+}
+
+func TestLogBetween(t *testing.T) {
+	now := time.Now()
+	l := log.Map(func(tr Transmission) Transmission {
+		tr.Time = now
+		now = now.Add(time.Second)
+		return tr
+	})
+	if log.T[0].Time != (time.Time{}) {
+		t.Fatal("expected Map not to modify the original log")
+	}
+	from, to := l.T[2].Time, l.T[6].Time
+	if p, exp := paths(t, l.Between(from, to)), []string{"/2", "/3"}; !equalStrings(p, exp) {
+		t.Errorf("expected paths=%v; got %v", exp, p)
+	}
+	if p, exp := paths(t, l.Between(to, time.Time{})), []string{"/4", "/5"}; !equalStrings(p, exp) {
+		t.Errorf("expected paths=%v; got %v", exp, p)
+	}
+}
+
+func TestMergeLogs(t *testing.T) {
+	ml := MergeLogs(log, &Log{Filter: "port 80", T: log.Where(ByPath("/1")).T})
+	if ml.Filter != "port 80" {
+		t.Errorf(`expected ml.Filter="port 80"; got %q`, ml.Filter)
+	}
+	conn, err := NewConnections(ml)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if len(conn) != 4 {
+		t.Fatalf("expected len(conn)=4; got %d", len(conn))
+	}
+	if len(conn[3]) != 1 || conn[3][0].Req.URL.Path != "/1" {
+		t.Errorf("expected conn[3] to hold a single /1 request; got %v", conn[3])
+	}
+	last := ml.T[len(ml.T)-2:]
+	if addrequal(last[0].Src, &cli[0]) {
+		t.Errorf("expected client address %v to be remapped", last[0].Src)
+	}
+	if !addrequal(last[0].Src, last[1].Dst) || !addrequal(last[0].Dst, srv) {
+		t.Errorf("expected %v <-> %v; got %v -> %v, %v -> %v", last[0].Src, srv,
+			last[0].Src, last[0].Dst, last[1].Src, last[1].Dst)
+	}
+}
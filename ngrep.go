@@ -9,6 +9,7 @@ import (
 	"net"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 var (
@@ -16,7 +17,7 @@ var (
 		regexp.MustCompile(`interface: [\w\d]+ \(([\.:\w\d]+)\/([\.:\w\d]+)\)`),
 		regexp.MustCompile(`filter: (.*)`),
 	}
-	tre = regexp.MustCompile(`T ([\.:\w\d]+) -> ([\.:\w\d]+)`)
+	tre = regexp.MustCompile(`T (unix://\S+|[\.:\w\d]+) -> (unix://\S+|[\.:\w\d]+)`)
 )
 
 func parseAddr(s string) (addr *net.TCPAddr, err error) {
@@ -75,10 +76,10 @@ func NgrepUnmarshal(r io.Reader, l *Log) error {
 			if m := tre.FindStringSubmatch(string(b)); m != nil {
 				l.T = append(l.T, Transmission{})
 				t = &l.T[len(l.T)-1]
-				if t.Src, err = parseAddr(m[1]); err != nil {
+				if t.Src, err = parsenetaddr(m[1]); err != nil {
 					return err
 				}
-				if t.Dst, err = parseAddr(m[2]); err != nil {
+				if t.Dst, err = parsenetaddr(m[2]); err != nil {
 					return err
 				}
 				st = stRaw
@@ -109,7 +110,7 @@ func NgrepUnmarshal(r io.Reader, l *Log) error {
 
 // NgrepMarshal writes to w the l encoded as a ngrep output.
 func NgrepMarshal(w io.Writer, l *Log) (err error) {
-	_, err = fmt.Fprintf(w, "interface: dunno0 (%s)\n", l.Net())
+	_, err = fmt.Fprintf(w, "interface: dunno0 (%s)\n", strings.Join(l.Net(), " "))
 	if err != nil {
 		return
 	}
@@ -117,7 +118,7 @@ func NgrepMarshal(w io.Writer, l *Log) (err error) {
 		return
 	}
 	for i := range l.T {
-		_, err = fmt.Fprintf(w, "\nT %s -> %s [AP]\n", l.T[i].Src.String(), l.T[i].Dst.String())
+		_, err = fmt.Fprintf(w, "\nT %s -> %s [AP]\n", addrstring(l.T[i].Src), addrstring(l.T[i].Dst))
 		if err != nil {
 			return
 		}
@@ -1,6 +1,9 @@
 package fakerpc
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
@@ -9,6 +12,7 @@ import (
 	"net/url"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 var noopRecord = func(*Transmission) {}
@@ -22,27 +26,99 @@ type recConn struct {
 	t      []Transmission
 	commit func([]Transmission)
 	rec    func(*Transmission)
-	src    *net.TCPAddr
-	dst    *net.TCPAddr
+	src    net.Addr
+	dst    net.Addr
 	wg     *sync.WaitGroup
 	onc    sync.Once
+	m      sync.Mutex // protects t
+	byreq  uint32     // whether transmissions are recorded per HTTP exchange
+	stream uint32     // last HTTP/2 stream identifier
+	ws     *wsrecorder
+	done   bool // whether the last transmission was checked for an upgrade
 }
 
+// TCPConn gives the underlying TCP connection, or nil for a Unix domain one.
 func (rc *recConn) TCPConn() *net.TCPConn {
-	return rc.Conn.(*net.TCPConn)
+	tc, _ := rc.Conn.(*net.TCPConn)
+	return tc
 }
 
-func (rc *recConn) record(p []byte, src, dst *net.TCPAddr) {
+func (rc *recConn) record(p []byte, src, dst net.Addr) {
+	if len(p) == 0 {
+		return
+	}
+	if atomic.LoadUint32(&rc.byreq) == 1 {
+		return
+	}
+	rc.m.Lock()
+	defer rc.m.Unlock()
+	if len(rc.t) == 1 && len(rc.t[0].Raw) == 0 && bytes.HasPrefix(p, h2preface) {
+		// HTTP/2 frames are not recorded, streams are recorded by serveRecord.
+		atomic.StoreUint32(&rc.byreq, 1)
+		rc.t = rc.t[:0]
+		return
+	}
+	if rc.ws != nil {
+		rc.recordFrames(p, src, dst)
+		return
+	}
 	if rc.t[len(rc.t)-1].Src != src {
 		rc.rec(&rc.t[len(rc.t)-1])
-		rc.t = append(rc.t, Transmission{})
+		rc.t, rc.done = append(rc.t, Transmission{}), false
+	}
+	t := &rc.t[len(rc.t)-1]
+	if t.Src == nil {
+		t.Src, t.Dst = src, dst
+	}
+	if len(t.Raw) == 0 {
+		t.Time = time.Now()
+	}
+	t.Raw = append(t.Raw, p...)
+	if src == rc.src && !rc.done {
+		rc.upgrade(t)
+	}
+}
+
+// upgrade checks whether the t is a response accepting WebSocket upgrade, once
+// its header is complete. After the upgrade every frame is recorded as
+// a separate transmission.
+func (rc *recConn) upgrade(t *Transmission) {
+	status := []byte("HTTP/1.1 101")
+	if len(t.Raw) < len(status) {
+		rc.done = !bytes.HasPrefix(status, t.Raw)
+		return
 	}
-	if len(p) != 0 {
-		t := &rc.t[len(rc.t)-1]
-		if t.Src == nil {
-			t.Src, t.Dst = src, dst
+	if !bytes.HasPrefix(t.Raw, status) {
+		rc.done = true
+		return
+	}
+	header, body := SplitHeaderBody(t.Raw)
+	if header == nil {
+		return
+	}
+	rc.done = true
+	if isWebSocketUpgrade(header) {
+		// Frames sent along with the response are recorded on their own.
+		t.Raw = header[:len(header):len(header)]
+		rc.rec(t)
+		rc.ws = &wsrecorder{}
+		if len(body) != 0 {
+			rc.recordFrames(body, t.Src, t.Dst)
 		}
-		t.Raw = append(t.Raw, p...)
+	}
+}
+
+// recordFrames records every complete WebSocket frame of an upgraded connection
+// as a separate transmission.
+func (rc *recConn) recordFrames(p []byte, src, dst net.Addr) {
+	dir := 0
+	if src == rc.src {
+		dir = 1
+	}
+	frames, times := rc.ws.record(p, dir)
+	for i := range frames {
+		rc.t = append(rc.t, Transmission{Src: src, Dst: dst, Raw: frames[i], Time: times[i]})
+		rc.rec(&rc.t[len(rc.t)-1])
 	}
 }
 
@@ -61,27 +137,52 @@ func (rc *recConn) Write(p []byte) (n int, err error) {
 func (rc *recConn) Close() (err error) {
 	rc.onc.Do(func() {
 		err = rc.Conn.Close()
-		if len(rc.t) > 0 && rc.t[len(rc.t)-1].Src == nil {
+		rc.m.Lock()
+		defer rc.m.Unlock()
+		if len(rc.t) > 0 && len(rc.t[len(rc.t)-1].Raw) == 0 {
+			// Nothing was sent over the connection after the last transmission.
 			rc.t = rc.t[:len(rc.t)-1]
 		}
-		rc.rec(&rc.t[len(rc.t)-1])
+		if len(rc.t) > 0 && rc.ws == nil && atomic.LoadUint32(&rc.byreq) == 0 {
+			rc.rec(&rc.t[len(rc.t)-1])
+		}
 		rc.commit(rc.t)
 		rc.wg.Done()
 	})
 	return
 }
 
+// recordExchange records a request and a response of a single HTTP exchange.
+func (rc *recConn) recordExchange(req, res Transmission) {
+	rc.m.Lock()
+	defer rc.m.Unlock()
+	rc.t = append(rc.t, req, res)
+	rc.rec(&rc.t[len(rc.t)-2])
+	rc.rec(&rc.t[len(rc.t)-1])
+}
+
+// nextStream gives a sequence number of the next HTTP/2 stream received on the
+// connection. The numbers follow the client-initiated identifiers, 1, 3, 5...,
+// but they are not read from the wire.
+func (rc *recConn) nextStream() uint32 {
+	return atomic.AddUint32(&rc.stream, 2) - 1
+}
+
 type recListener struct {
 	log Log
 	wg  sync.WaitGroup
 	m   sync.Mutex // protects log and con
 	lis net.Listener
-	src *net.TCPAddr
-	dst *net.TCPAddr
+	src net.Addr
 	rec func(*Transmission)
 	con map[io.Closer]struct{}
+	snk func([]Transmission) error
+	red []string
 	onc sync.Once
 	tmp bool
+	tls bool
+	tcp bool    // whether the lis is a TCP listener
+	num *uint32 // number of accepted connections of unnamed clients
 }
 
 // ListenAndRecord announces on the local network address laddr, recording all the communication.
@@ -95,14 +196,19 @@ func ListenAndRecord(network, laddr string, callback func(*Transmission)) (net.L
 	return Record(lis, callback)
 }
 
-// Record records all network communication on the listener.
+// Record records all network communication on the listener, either a TCP or
+// a Unix domain socket one.
 //
 // On failure it closes the listener returning non-nil error.
 func Record(lis net.Listener, callback func(*Transmission)) (net.Listener, error) {
-	src, err := tcpaddr(lis.Addr())
-	if err != nil {
-		lis.Close()
-		return nil, err
+	var src net.Addr = lis.Addr()
+	if _, ok := src.(*net.UnixAddr); !ok {
+		tcpa, err := tcpaddr(src)
+		if err != nil {
+			lis.Close()
+			return nil, err
+		}
+		src = tcpa
 	}
 	rl, err := newRecListener(lis, src, callback)
 	if err != nil {
@@ -113,21 +219,32 @@ func Record(lis net.Listener, callback func(*Transmission)) (net.Listener, error
 	return rl, nil
 }
 
-func newRecListener(lis net.Listener, src *net.TCPAddr, rec func(*Transmission)) (l *recListener, err error) {
-	networks, err := ipnetaddr(lis.Addr())
-	if err != nil {
-		return
+// newRecListener gives a recListener recording connections accepted by
+// the lis as ones to the src. Networks of a log recorded on a listener other
+// than a TCP one, e.g. a Unix domain socket or an in-memory one, are empty;
+// so is its filter, when the src is a Unix domain socket.
+func newRecListener(lis net.Listener, src net.Addr, rec func(*Transmission)) (l *recListener, err error) {
+	var networks []*net.IPNet
+	tcp := istcp(lis.Addr())
+	if tcp {
+		if networks, err = ipnetaddr(lis.Addr()); err != nil {
+			return
+		}
 	}
 	l = &recListener{
 		log: Log{
 			Networks: networks,
-			Filter:   fmt.Sprintf("(ip or ipv6) and ( host %s and port %d )", src.IP, src.Port),
 			T:        make([]Transmission, 0),
 		},
 		lis: lis,
 		src: src,
 		rec: rec,
+		tcp: tcp,
 		con: make(map[io.Closer]struct{}),
+		num: new(uint32),
+	}
+	if src, ok := src.(*net.TCPAddr); ok {
+		l.log.Filter = fmt.Sprintf("(ip or ipv6) and ( host %s and port %d )", src.IP, src.Port)
 	}
 	return
 }
@@ -137,8 +254,12 @@ func (rl *recListener) Accept() (net.Conn, error) {
 	if err != nil {
 		return nil, err
 	}
-	dst, err := tcpaddr(c.RemoteAddr())
-	if err != nil {
+	var dst net.Addr
+	if !rl.tcp {
+		// Clients of Unix domain sockets are usually unnamed, so are the ones
+		// of in-memory listeners.
+		dst = unixpeer(atomic.AddUint32(rl.num, 1))
+	} else if dst, err = tcpaddr(c.RemoteAddr()); err != nil {
 		c.Close()
 		return nil, err
 	}
@@ -154,6 +275,9 @@ func (rl *recListener) Accept() (net.Conn, error) {
 		wg:  &rl.wg,
 		rec: rl.rec,
 	}
+	if rl.tls {
+		conn.t, conn.byreq = conn.t[:0], 1
+	}
 	if rl.tmp {
 		conn.commit = func([]Transmission) {
 			rl.m.Lock()
@@ -162,8 +286,14 @@ func (rl *recListener) Accept() (net.Conn, error) {
 		}
 	} else {
 		conn.commit = func(t []Transmission) {
+			Redact(t, rl.red)
+			if rl.snk != nil && len(t) != 0 {
+				rl.snk(t)
+			}
 			rl.m.Lock()
-			rl.log.T = append(rl.log.T, t...)
+			if rl.snk == nil {
+				rl.log.T = append(rl.log.T, t...)
+			}
 			delete(rl.con, conn)
 			rl.m.Unlock()
 		}
@@ -176,29 +306,130 @@ func (rl *recListener) Accept() (net.Conn, error) {
 }
 
 // A proxytransport preserves original Host header from client's request.
+// HTTP/2 requests are sent to the target over HTTP/2 as well; when h2c is
+// true, all the requests are sent over HTTP/2 with a prior knowledge.
 type proxytransport struct {
 	tr   http.RoundTripper
+	h2   http.RoundTripper
 	host string
 }
 
 func (pt proxytransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	req.Host = pt.host
+	if pt.host != "" {
+		req.Host = pt.host
+	}
+	if req.ProtoMajor == 2 {
+		return pt.h2.RoundTrip(req)
+	}
 	return pt.tr.RoundTrip(req)
 }
 
-func newProxyTransport(u *url.URL) http.RoundTripper {
-	return proxytransport{
-		tr:   &http.Transport{},
+func newProxyTransport(u *url.URL, h2c bool) http.RoundTripper {
+	h2 := &http.Transport{Protocols: &http.Protocols{}}
+	h2.Protocols.SetHTTP2(true)
+	h2.Protocols.SetUnencryptedHTTP2(true)
+	tr := &http.Transport{}
+	if u.Scheme == "unix" {
+		tr.DialContext, h2.DialContext = dialUnix(u.Path), dialUnix(u.Path)
+	}
+	pt := proxytransport{
+		tr:   tr,
+		h2:   h2,
 		host: u.Host,
 	}
+	if h2c {
+		pt.tr = h2
+	}
+	return pt
 }
 
-func newReverseProxy(u *url.URL) *httputil.ReverseProxy {
-	p := httputil.NewSingleHostReverseProxy(u)
-	p.Transport = newProxyTransport(u)
+// dialUnix gives a dial function of a http.Transport, which connects to the Unix
+// domain socket at the path regardless of the requested address.
+func dialUnix(path string) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", path)
+	}
+}
+
+func newReverseProxy(u *url.URL, h2c bool) *httputil.ReverseProxy {
+	targ := u
+	if u.Scheme == "unix" {
+		// Requests keep their Host, as the socket has no host name.
+		targ = &url.URL{Scheme: "http", Host: "localhost"}
+	}
+	p := httputil.NewSingleHostReverseProxy(targ)
+	p.Transport = newProxyTransport(u, h2c)
 	return p
 }
 
+// A proxyhandler tunnels CONNECT requests (e.g. net/rpc over HTTP) to the
+// target, passing all other requests to the reverse proxy.
+type proxyhandler struct {
+	rp   *httputil.ReverseProxy
+	targ *url.URL
+}
+
+func newProxyHandler(u *url.URL, h2c bool) http.Handler {
+	return proxyhandler{rp: newReverseProxy(u, h2c), targ: u}
+}
+
+func (ph proxyhandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "CONNECT" {
+		if rc := recConnOf(req); rc != nil && atomic.LoadUint32(&rc.byreq) == 1 {
+			rc.serveRecord(ph.rp, rw, req)
+			return
+		}
+		ph.rp.ServeHTTP(rw, req)
+		return
+	}
+	if rc := recConnOf(req); rc != nil && atomic.LoadUint32(&rc.byreq) == 1 {
+		// Tunnels are recorded from the raw bytes of a connection, which are
+		// not available for TLS connections and HTTP/2 streams.
+		http.Error(rw, "fakerpc: CONNECT is not supported over TLS or HTTP/2", http.StatusNotImplemented)
+		return
+	}
+	addr, err := urltoaddr(ph.targ)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		return
+	}
+	dst, err := net.Dial(addr.Network(), addr.String())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hj, ok := rw.(http.Hijacker)
+	if !ok {
+		dst.Close()
+		http.Error(rw, "fakerpc: unable to hijack the connection", http.StatusInternalServerError)
+		return
+	}
+	src, buf, err := hj.Hijack()
+	if err != nil {
+		dst.Close()
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if ph.targ.Host != "" {
+		req.Host = ph.targ.Host
+	}
+	if err = req.Write(dst); err != nil {
+		dst.Close()
+		src.Close()
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		io.Copy(src, dst)
+		src.Close()
+		close(done)
+	}()
+	io.Copy(dst, buf)
+	dst.Close()
+	<-done
+}
+
 func (rl *recListener) Wait() {
 	rl.wg.Wait()
 }
@@ -206,22 +437,63 @@ func (rl *recListener) Wait() {
 func (rl *recListener) Close() (err error) {
 	rl.onc.Do(func() {
 		err = rl.lis.Close()
-		for c := range rl.con {
-			c.Close()
-		}
+		rl.closeConns()
 	})
 	return
 }
 
+// closeConns closes all the connections accepted by the rl, which are still
+// open.
+func (rl *recListener) closeConns() {
+	rl.m.Lock()
+	con := make([]io.Closer, 0, len(rl.con))
+	for c := range rl.con {
+		con = append(con, c)
+	}
+	rl.m.Unlock()
+	for _, c := range con {
+		c.Close()
+	}
+}
+
+// A gracefulListener is a recListener, which Close leaves accepted connections
+// open, so the http.Server can shut them down gracefully.
+type gracefulListener struct {
+	*recListener
+}
+
+func (gl gracefulListener) Close() error {
+	return gl.lis.Close()
+}
+
 func (rl *recListener) Addr() net.Addr {
 	return rl.lis.Addr()
 }
 
 // A Proxy represents a single host HTTP reverse proxy which records all the
 // transmission it handles.
+//
+// Proxy accepts both HTTP/1.x and HTTP/2 connections, the latter either with
+// a prior knowledge (h2c) or negotiated via TLS ALPN when TLSConfig is set.
+// HTTP/2 streams, e.g. gRPC calls, are recorded as a request/response pair
+// of transmissions with the Stream field set.
+//
+// By default recorded transmissions are kept in memory until the Proxy is
+// stopped. When Sink is set, transmissions of each connection are written to
+// it as soon as the connection is closed instead.
 type Proxy struct {
 	// Record function is called after each transmission is successfully completed.
 	Record func(*Transmission)
+	// Sink, when non-nil, receives transmissions of every closed connection.
+	// The log returned by Stop holds no transmissions then.
+	Sink LogSink
+	// TLSConfig, when non-nil, makes the Proxy serve TLS connections. CONNECT
+	// requests sent over TLS are rejected, as their tunnels can't be recorded.
+	TLSConfig *tls.Config
+	// Redact is a list of headers, which values are replaced with
+	// the RedactedValue in the recorded requests and responses. The Record
+	// function receives transmissions before they're redacted.
+	Redact []string
 	m      sync.Mutex
 	wgr    sync.WaitGroup
 	targ   *url.URL
@@ -229,20 +501,37 @@ type Proxy struct {
 	srv    *http.Server
 	addr   string
 	isrun  uint32
+	peers  uint32 // number of connections of unnamed clients in all sessions
 }
 
 // NewProxy gives new Proxy for the given target URL and listening on the given
-// TCP network address.
+// TCP network address. The target URL with h2c scheme makes the Proxy talk to
+// the target over HTTP/2 with a prior knowledge. Both the addr and the target
+// can be a path of a Unix domain socket prefixed with "unix://", e.g.
+// unix:///run/app.sock.
 func NewProxy(addr, target string) (*Proxy, error) {
-	u, err := url.Parse(target)
-	if err != nil {
+	var (
+		u   *url.URL
+		err error
+	)
+	if network, path := splitaddr(target); network == "unix" {
+		u = &url.URL{Scheme: "unix", Path: path}
+	} else if u, err = url.Parse(target); err != nil {
 		return nil, err
 	}
+	h2c := u.Scheme == "h2c"
+	if h2c {
+		u.Scheme = "http"
+	}
 	p := &Proxy{
 		Record: noopRecord,
 		targ:   u,
 		addr:   addr,
-		srv:    &http.Server{Handler: newReverseProxy(u)},
+		srv: &http.Server{
+			Handler:     newProxyHandler(u, h2c),
+			Protocols:   h2protos,
+			ConnContext: withConn,
+		},
 	}
 	p.wgr.Add(1)
 	return p, nil
@@ -250,7 +539,22 @@ func NewProxy(addr, target string) (*Proxy, error) {
 
 // ListenAndServe starts listening for connections, recording them and proxying
 // to the target URL.
-func (p *Proxy) ListenAndServe() (err error) {
+func (p *Proxy) ListenAndServe() error {
+	if atomic.LoadUint32(&p.isrun) == 1 {
+		return ErrAlreadyRunning
+	}
+	l, err := net.Listen(splitaddr(p.addr))
+	if err != nil {
+		return err
+	}
+	return p.Serve(l)
+}
+
+// Serve is like ListenAndServe, but it accepts connections on the l instead of
+// listening on the p's address. The l is closed when Serve returns. Logs
+// recorded on listeners other than TCP ones have no networks and their clients
+// are numbered, like the ones of Unix domain sockets.
+func (p *Proxy) Serve(l net.Listener) (err error) {
 	if atomic.CompareAndSwapUint32(&p.isrun, 0, 1) {
 		defer func() {
 			// Ignore "use of closed network connection" comming from closed
@@ -260,26 +564,42 @@ func (p *Proxy) ListenAndServe() (err error) {
 			}
 		}()
 		p.m.Lock()
-		var l net.Listener
-		if l, err = net.Listen("tcp", p.addr); err != nil {
-			p.m.Unlock()
-			return
-		}
-		var src *net.TCPAddr
-		src, err = urltotcpaddr(p.targ)
+		var src net.Addr
+		src, err = urltoaddr(p.targ)
 		if err != nil {
+			l.Close()
 			p.m.Unlock()
 			return
 		}
 		if p.rl, err = newRecListener(l, src, p.Record); err != nil {
+			l.Close()
 			p.m.Unlock()
 			return
 		}
+		// Unnamed clients are numbered across sessions, as they may be
+		// recorded to the same Sink.
+		p.rl.red, p.rl.num = p.Redact, &p.peers
+		if p.Sink != nil {
+			err = p.Sink.WriteHeader(p.rl.log.Networks, p.rl.log.Filter)
+			if err != nil && err != errHeaderWritten {
+				p.rl.Close()
+				p.m.Unlock()
+				return
+			}
+			err, p.rl.snk = nil, p.Sink.Write
+		}
+		l = gracefulListener{p.rl}
+		if p.TLSConfig != nil {
+			p.rl.tls = true
+			l = tls.NewListener(l, tlsconfig(p.TLSConfig))
+		}
+		srv := p.srv
 		p.wgr.Done()
 		p.m.Unlock()
-		err = p.srv.Serve(p.rl)
+		err = srv.Serve(l)
 		return
 	}
+	l.Close()
 	return ErrAlreadyRunning
 }
 
@@ -293,13 +613,18 @@ func (p *Proxy) Addr() (addr net.Addr) {
 }
 
 // Stop stops the Proxy from accepting new connections. It waits for on-going
-// connections to finish, ensuring all of them were captured in the l.
+// connections to finish, ensuring all of them were captured in the l or
+// written to the Sink. In the latter case it reports the first error writing
+// to the Sink.
 func (p *Proxy) Stop() (l *Log, err error) {
 	err = ErrNotRunning
 	if atomic.CompareAndSwapUint32(&p.isrun, 1, 0) {
 		p.wgr.Wait()
 		p.m.Lock()
 		l, err = &p.rl.log, p.rl.Close()
+		if err == nil && p.Sink != nil {
+			err = p.Sink.Err()
+		}
 		p.rl = nil
 		p.wgr.Add(1)
 		p.m.Unlock()
@@ -307,3 +632,46 @@ func (p *Proxy) Stop() (l *Log, err error) {
 	}
 	return
 }
+
+// Shutdown stops the Proxy gracefully. It stops accepting new connections,
+// closes the idle ones and waits for the others to complete their exchanges,
+// so none of them is recorded partially. If the ctx is done first, the remaining
+// connections are closed forcibly, like with Stop, and the ctx's error is
+// returned. Otherwise Shutdown reports the first error writing to the Sink.
+// The l holds the recorded transmissions in both cases.
+func (p *Proxy) Shutdown(ctx context.Context) (l *Log, err error) {
+	if !atomic.CompareAndSwapUint32(&p.isrun, 1, 0) {
+		return nil, ErrNotRunning
+	}
+	p.wgr.Wait()
+	p.m.Lock()
+	defer p.m.Unlock()
+	// The http.Server does not track hijacked connections, e.g. tunnels,
+	// which are waited for by the recListener.
+	if err = p.srv.Shutdown(ctx); err == nil {
+		done := make(chan struct{})
+		go func() {
+			p.rl.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	p.rl.Close()
+	p.rl.Wait()
+	if err == nil && p.Sink != nil {
+		err = p.Sink.Err()
+	}
+	l, p.rl = &p.rl.log, nil
+	// A http.Server can't be started again after it was shut down.
+	p.srv = &http.Server{
+		Handler:     p.srv.Handler,
+		Protocols:   p.srv.Protocols,
+		ConnContext: p.srv.ConnContext,
+	}
+	p.wgr.Add(1)
+	return l, err
+}
@@ -2,10 +2,17 @@ package fakerpc
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 )
 
 func httpsrv(t *testing.T) string {
@@ -155,3 +162,366 @@ func TestProxy(t *testing.T) {
 		}
 	}
 }
+
+func TestProxyShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		d, _ := time.ParseDuration(req.URL.Query().Get("sleep"))
+		time.Sleep(d)
+		io.WriteString(w, "OK")
+	}))
+	cases := [...]struct {
+		sleep   string
+		timeout time.Duration
+		err     error
+		n       int // number of recorded transmissions
+	}{
+		{"50ms", time.Second, nil, 4},
+		{"300ms", 20 * time.Millisecond, context.DeadlineExceeded, 3},
+	}
+	for i, cas := range cases {
+		p, err := NewProxy("localhost:0", "http://"+l.Addr().String())
+		if err != nil {
+			t.Fatalf("expected err=nil; got %q (i=%d)", err, i)
+		}
+		go p.ListenAndServe()
+		tr := &http.Transport{}
+		client := &http.Client{Transport: tr}
+		addr := "http://" + p.Addr().String()
+		res, err := client.Get(addr + "/")
+		if err != nil {
+			t.Fatalf("expected err=nil; got %q (i=%d)", err, i)
+		}
+		res.Body.Close()
+		done := make(chan error, 1)
+		go func() {
+			// A separate client dials exactly one connection for the request.
+			client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+			res, err := client.Get(addr + "/?sleep=" + cas.sleep)
+			if err == nil {
+				res.Body.Close()
+			}
+			done <- err
+		}()
+		time.Sleep(10 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), cas.timeout)
+		log, err := p.Shutdown(ctx)
+		cancel()
+		if err != cas.err {
+			t.Errorf("expected err=%v; got %v (i=%d)", cas.err, err, i)
+		}
+		if err = <-done; (err == nil) != (cas.err == nil) {
+			t.Errorf("expected the in-flight request to fail only on timeout; got %v (i=%d)", err, i)
+		}
+		if log == nil || len(log.T) != cas.n {
+			t.Errorf("expected %d transmissions; got %v (i=%d)", cas.n, log, i)
+		}
+		tr.CloseIdleConnections()
+		if _, err = p.Shutdown(context.Background()); err != ErrNotRunning {
+			t.Errorf("expected err=ErrNotRunning; got %v (i=%d)", err, i)
+		}
+	}
+}
+
+func TestProxyServe(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "OK")
+	}))
+	p, err := NewProxy("", "http://"+l.Addr().String())
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(lis)
+	if addr := p.Addr(); addr.String() != lis.Addr().String() {
+		t.Errorf("expected addr=%s; got %s", lis.Addr(), addr)
+	}
+	tr := &http.Transport{}
+	res, err := (&http.Client{Transport: tr}).Get("http://" + lis.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	res.Body.Close()
+	tr.CloseIdleConnections()
+	log, err := p.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if len(log.T) != 2 {
+		t.Errorf("expected 2 transmissions; got %d", len(log.T))
+	}
+}
+
+// A pipeListener is an in-memory net.Listener accepting net.Pipe connections
+// made with dial.
+type pipeListener struct {
+	c    chan net.Conn
+	done chan struct{}
+	once sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{c: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (pl *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-pl.c:
+		return c, nil
+	case <-pl.done:
+		return nil, errors.New("pipe listener closed")
+	}
+}
+
+func (pl *pipeListener) Close() error {
+	pl.once.Do(func() { close(pl.done) })
+	return nil
+}
+
+func (pl *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+func (pl *pipeListener) dial(context.Context, string, string) (net.Conn, error) {
+	c, s := net.Pipe()
+	select {
+	case pl.c <- s:
+		return c, nil
+	case <-pl.done:
+		return nil, errors.New("pipe listener closed")
+	}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+func TestProxyServePipe(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "OK")
+	}))
+	p, err := NewProxy("", "http://"+l.Addr().String())
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	pl := newPipeListener()
+	done := make(chan error, 1)
+	go func() { done <- p.Serve(pl) }()
+	tr := &http.Transport{DialContext: pl.dial}
+	res, err := (&http.Client{Transport: tr}).Get("http://fake/")
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	res.Body.Close()
+	tr.CloseIdleConnections()
+	log, err := p.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if err = <-done; err != nil {
+		t.Errorf("expected err=nil; got %q", err)
+	}
+	if len(log.Networks) != 0 {
+		t.Errorf("expected no networks; got %v", log.Networks)
+	}
+	if len(log.T) != 2 {
+		t.Fatalf("expected 2 transmissions; got %d", len(log.T))
+	}
+	if !addrequal(log.T[0].Src, unixpeer(1)) {
+		t.Errorf("expected client=@1; got %v", log.T[0].Src)
+	}
+}
+
+func TestProxyRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakerpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	targ, addr := filepath.Join(dir, "targ.sock"), filepath.Join(dir, "proxy.sock")
+	l, err := net.Listen("unix", targ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, req.URL.Path)
+	}))
+	p, err := NewProxy("unix://"+addr, "unix://"+targ)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	var buf lockedbuf
+	p.Sink = NewLogWriter(&buf)
+	for i, path := range []string{"/a", "/b"} {
+		// Every session records a single connection to the same Sink.
+		go p.ListenAndServe()
+		p.Addr()
+		client, tr := unixclient(addr)
+		res, err := client.Get("http://fake" + path)
+		if err != nil {
+			t.Fatalf("expected err=nil; got %q (i=%d)", err, i)
+		}
+		res.Body.Close()
+		tr.CloseIdleConnections()
+		if _, err = p.Stop(); err != nil {
+			t.Fatalf("expected err=nil; got %q (i=%d)", err, i)
+		}
+	}
+	dl, _, err := DecodeLog(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	conns, err := NewConnections(dl)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if len(conns) != 2 {
+		t.Fatalf("expected 2 connections; got %d", len(conns))
+	}
+	for i, path := range []string{"/a", "/b"} {
+		if len(conns[i]) != 1 || conns[i][0].Req.URL.Path != path {
+			t.Errorf("expected connection with %s request; got %v (i=%d)", path, conns[i], i)
+		}
+	}
+}
+
+// unixclient gives a client, which connects to the Unix domain socket at
+// the path.
+func unixclient(path string) (*http.Client, *http.Transport) {
+	tr := &http.Transport{DialContext: dialUnix(path)}
+	return &http.Client{Transport: tr}, tr
+}
+
+func TestProxyUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakerpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	targ, addr := filepath.Join(dir, "targ.sock"), filepath.Join(dir, "proxy.sock")
+	l, err := net.Listen("unix", targ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, req.URL.Path)
+	}))
+	p, err := NewProxy("unix://"+addr, "unix://"+targ)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	go p.ListenAndServe()
+	if a := p.Addr(); a.String() != addr {
+		t.Errorf("expected addr=%s; got %s", addr, a)
+	}
+	paths := []string{"/a", "/b"}
+	for _, path := range paths {
+		// Every request is sent over a separate connection.
+		client, tr := unixclient(addr)
+		res, err := client.Get("http://fake" + path)
+		if err != nil {
+			t.Fatalf("expected err=nil; got %q", err)
+		}
+		res.Body.Close()
+		tr.CloseIdleConnections()
+	}
+	rec, err := p.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if len(rec.Networks) != 0 || rec.Filter != "" {
+		t.Errorf("expected no networks nor filter; got %v, %q", rec.Networks, rec.Filter)
+	}
+	var buf bytes.Buffer
+	if err = EncodeLog(&buf, rec); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	dl, _, err := DecodeLog(&buf)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if len(dl.T) != 4 {
+		t.Fatalf("expected 4 transmissions; got %d", len(dl.T))
+	}
+	addrs := [...]struct {
+		src, dst string
+	}{
+		{"@1", targ},
+		{targ, "@1"},
+		{"@2", targ},
+		{targ, "@2"},
+	}
+	for i, tr := range dl.T {
+		if ua, ok := tr.Src.(*net.UnixAddr); !ok || ua.Name != addrs[i].src {
+			t.Errorf("expected T[%d].Src=%s; got %v", i, addrs[i].src, tr.Src)
+		}
+		if ua, ok := tr.Dst.(*net.UnixAddr); !ok || ua.Name != addrs[i].dst {
+			t.Errorf("expected T[%d].Dst=%s; got %v", i, addrs[i].dst, tr.Dst)
+		}
+	}
+	buf.Reset()
+	if err = JSONMarshal(&buf, dl); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	jl := NewLog()
+	if err = JSONUnmarshal(&buf, jl); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	for i := range dl.T {
+		if !addrequal(jl.T[i].Src, dl.T[i].Src) || !addrequal(jl.T[i].Dst, dl.T[i].Dst) {
+			t.Errorf("expected jl.T[%d]=%v -> %v; got %v -> %v", i, dl.T[i].Src, dl.T[i].Dst, jl.T[i].Src, jl.T[i].Dst)
+		}
+	}
+	ml := MergeLogs(dl, dl)
+	if !addrequal(ml.T[4].Src, unixpeer(3)) || !addrequal(ml.T[6].Src, unixpeer(4)) {
+		t.Errorf("expected merged clients to be remapped to @3 and @4; got %v, %v", ml.T[4].Src, ml.T[6].Src)
+	}
+	saddr := filepath.Join(dir, "srv.sock")
+	srv, err := NewServer("unix://"+saddr, dl)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.ListenAndServe()
+	}()
+	if a := srv.Addr(); a.String() != saddr {
+		t.Errorf("expected addr=%s; got %s", saddr, a)
+	}
+	for _, path := range paths {
+		client, tr := unixclient(saddr)
+		res, err := client.Get("http://fake" + path)
+		if err != nil {
+			t.Fatalf("expected err=nil; got %q", err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil || string(body) != path {
+			t.Errorf("expected body=%s, err=nil; got %q, %v", path, body, err)
+		}
+		tr.CloseIdleConnections()
+	}
+	if err = <-done; err != nil {
+		t.Errorf("expected err=nil; got %q", err)
+	}
+}
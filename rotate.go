@@ -0,0 +1,263 @@
+package fakerpc
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A LogRotator is a LogSink, which writes a log to numbered files, starting
+// a new one when the current one reaches any of the limits. The first file is
+// the one given to NewLogRotator; if its name ends with a number, the following
+// files are numbered with the next free ones, otherwise the numbering starts
+// from 1. Every file is a complete log. It's safe for concurrent use.
+//
+// Files are rotated only when a chunk is written, so an idle file is kept open
+// regardless of the Interval.
+type LogRotator struct {
+	// MaxSize, when positive, is a size in bytes a file is rotated after.
+	MaxSize int64
+	// MaxConns, when positive, is a number of connections a file is rotated
+	// after.
+	MaxConns int
+	// Interval, when positive, is a duration a file is rotated after.
+	Interval time.Duration
+	// MaxTotal, when positive, is a total size in bytes of the files, above which
+	// the oldest ones are removed. The current file is never removed.
+	MaxTotal int64
+	// MaxFiles, when positive, is a number of files, above which the oldest ones
+	// are removed.
+	MaxFiles int
+	// SyncInterval, Store and Threshold are passed to the LogWriter of every
+	// file.
+	SyncInterval time.Duration
+	Store        BodyStore
+	Threshold    int
+	// Key, when non-empty, is a passphrase every file is encrypted with (see
+	// Encrypt).
+	Key string
+	// Rotate function, when non-nil, is called with a name of every new file.
+	Rotate   func(file string)
+	m        sync.Mutex
+	file     string
+	lw       *LogWriter
+	cw       *countWriter
+	conns    int
+	opened   time.Time
+	files    []string
+	hdr      bool
+	networks []*net.IPNet
+	filter   string
+	err      error
+}
+
+// NewLogRotator gives a new LogRotator, which begins writing to the file.
+func NewLogRotator(file string) *LogRotator {
+	return &LogRotator{file: file}
+}
+
+// A countWriter is a file, which counts bytes written to it.
+type countWriter struct {
+	*os.File
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.File.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// WriteHeader stores log's metadata, which is written to every file.
+func (lr *LogRotator) WriteHeader(networks []*net.IPNet, filter string) error {
+	lr.m.Lock()
+	defer lr.m.Unlock()
+	if lr.hdr {
+		return errHeaderWritten
+	}
+	lr.hdr, lr.networks, lr.filter = true, networks, filter
+	return nil
+}
+
+// Write appends the t to the current file, rotating it first if it reached any
+// of the limits.
+func (lr *LogRotator) Write(t []Transmission) error {
+	lr.m.Lock()
+	defer lr.m.Unlock()
+	if lr.err != nil {
+		return lr.err
+	}
+	switch {
+	case lr.lw == nil:
+		lr.err = lr.open(lr.file)
+	case lr.full():
+		if lr.err = lr.lw.Close(); lr.err == nil {
+			lr.err = lr.open(nextfile(lr.file))
+		}
+	}
+	if lr.err != nil {
+		return lr.err
+	}
+	if lr.err = lr.lw.Write(t); lr.err != nil {
+		return lr.err
+	}
+	lr.conns++
+	return nil
+}
+
+func (lr *LogRotator) full() bool {
+	return (lr.MaxSize > 0 && lr.cw.n >= lr.MaxSize) ||
+		(lr.MaxConns > 0 && lr.conns >= lr.MaxConns) ||
+		(lr.Interval > 0 && time.Since(lr.opened) >= lr.Interval)
+}
+
+func (lr *LogRotator) open(file string) error {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	lr.file, lr.cw, lr.conns, lr.opened = file, &countWriter{File: f}, 0, time.Now()
+	var w io.Writer = lr.cw
+	if lr.Key != "" {
+		if w, err = Encrypt(lr.cw, lr.Key); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	lr.lw = NewLogWriter(w)
+	lr.lw.SyncInterval, lr.lw.Store, lr.lw.Threshold = lr.SyncInterval, lr.Store, lr.Threshold
+	if err = lr.lw.WriteHeader(lr.networks, lr.filter); err != nil {
+		return err
+	}
+	lr.files = append(lr.files, file)
+	if lr.Rotate != nil {
+		lr.Rotate(file)
+	}
+	return lr.prune()
+}
+
+// prune removes the oldest files until the retention limits are met.
+func (lr *LogRotator) prune() error {
+	for len(lr.files) > 1 {
+		if lr.MaxFiles > 0 && len(lr.files) > lr.MaxFiles {
+			if err := lr.remove(); err != nil {
+				return err
+			}
+			continue
+		}
+		if lr.MaxTotal <= 0 {
+			return nil
+		}
+		var total int64
+		for _, file := range lr.files[:len(lr.files)-1] {
+			if fi, err := os.Stat(file); err == nil {
+				total += fi.Size()
+			}
+		}
+		if total+lr.cw.n <= lr.MaxTotal {
+			return nil
+		}
+		if err := lr.remove(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (lr *LogRotator) remove() error {
+	if err := os.Remove(lr.files[0]); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lr.files = lr.files[1:]
+	return nil
+}
+
+// nextfile gives a name of the next free numbered file following the file.
+func nextfile(file string) string {
+	base, n := splitnum(file)
+	for n++; ; n++ {
+		path := fmt.Sprintf("%s.%d", base, n)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+	}
+}
+
+// splitnum splits the numbered file into its base name and the number; the n
+// is 0 if the file is not numbered.
+func splitnum(file string) (base string, n int) {
+	if i := strings.LastIndex(file, "."); i != -1 {
+		if k, err := strconv.Atoi(file[i+1:]); err == nil {
+			return file[:i], k
+		}
+	}
+	return file, 0
+}
+
+// LogFiles gives the record-log files found in the dir and its subdirectories,
+// including the numbered ones written by a LogRotator, which follow the file
+// they were rotated from in the order of their numbers.
+func LogFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			if c, ok := CodecByExt(path); ok && c.Name == "gob" {
+				files = append(files, path)
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		bi, ni := splitnum(files[i])
+		bj, nj := splitnum(files[j])
+		if bi != bj {
+			return bi < bj
+		}
+		return ni < nj
+	})
+	return files, nil
+}
+
+// Files gives names of the files, which were written and were not removed yet,
+// the oldest first.
+func (lr *LogRotator) Files() []string {
+	lr.m.Lock()
+	defer lr.m.Unlock()
+	return append([]string(nil), lr.files...)
+}
+
+// Err gives the first error, which occurred while writing the log.
+func (lr *LogRotator) Err() error {
+	lr.m.Lock()
+	defer lr.m.Unlock()
+	return lr.err
+}
+
+// Close closes the current file, creating it if nothing was written yet, and
+// applies the retention limits.
+func (lr *LogRotator) Close() error {
+	lr.m.Lock()
+	defer lr.m.Unlock()
+	if lr.lw == nil && lr.err == nil {
+		lr.err = lr.open(lr.file)
+	}
+	if lr.lw != nil {
+		if err := lr.lw.Close(); lr.err == nil {
+			lr.err = err
+		}
+	}
+	if lr.err == nil {
+		lr.err = lr.prune()
+	}
+	return lr.err
+}
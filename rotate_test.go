@@ -0,0 +1,117 @@
+package fakerpc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogRotator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakerpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var rotated []string
+	lr := NewLogRotator(filepath.Join(dir, "log.gzob.0"))
+	lr.MaxConns, lr.MaxFiles = 1, 2
+	lr.Rotate = func(file string) { rotated = append(rotated, filepath.Base(file)) }
+	if err = lr.WriteHeader(lexp.Networks, lexp.Filter); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	conns := log.Conns()
+	for _, conn := range conns {
+		if err = lr.Write(conn.T); err != nil {
+			t.Fatalf("expected err=nil; got %q", err)
+		}
+	}
+	if err = lr.Close(); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	exp := []string{"log.gzob.0", "log.gzob.1", "log.gzob.2"}
+	if !equalStrings(rotated, exp) {
+		t.Errorf("expected rotated=%v; got %v", exp, rotated)
+	}
+	files := lr.Files()
+	if len(files) != 2 {
+		t.Fatalf("expected len(files)=2; got %d", len(files))
+	}
+	if _, err = os.Stat(filepath.Join(dir, exp[0])); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed; got %v", exp[0], err)
+	}
+	found, err := LogFiles(dir)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if !equalStrings(found, files) {
+		t.Errorf("expected LogFiles=%v; got %v", files, found)
+	}
+	for i, file := range found {
+		l, err := ReadLog(file)
+		if err != nil {
+			t.Fatalf("expected err=nil; got %q (i=%d)", err, i)
+		}
+		if l.Filter != lexp.Filter {
+			t.Errorf("expected l.Filter=%q; got %q (i=%d)", lexp.Filter, l.Filter, i)
+		}
+		if c := conns[i+1]; len(l.T) != len(c.T) {
+			t.Errorf("expected len(l.T)=%d; got %d (i=%d)", len(c.T), len(l.T), i)
+		}
+	}
+}
+
+func TestLogRotatorMaxTotal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakerpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lr := NewLogRotator(filepath.Join(dir, "log"))
+	lr.MaxSize = 1
+	for i := 0; i < 4; i++ {
+		if err = lr.Write(log.T); err != nil {
+			t.Fatalf("expected err=nil; got %q", err)
+		}
+	}
+	fi, err := os.Stat(filepath.Join(dir, "log"))
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	lr.MaxTotal = 2*fi.Size() + 1
+	if err = lr.Close(); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	exp := []string{filepath.Join(dir, "log.2"), filepath.Join(dir, "log.3")}
+	if files := lr.Files(); !equalStrings(files, exp) {
+		t.Errorf("expected files=%v; got %v", exp, files)
+	}
+}
+
+func TestLogFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakerpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, file := range []string{"a.gzob.10", "a.gzob", "a.gzob.2", "a.json", ".a.gzob.tmp123", "sub/b.gzob.1"} {
+		file = filepath.Join(dir, file)
+		if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := LogFiles(dir)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	exp := []string{"a.gzob", "a.gzob.2", "a.gzob.10", "sub/b.gzob.1"}
+	for i := range exp {
+		exp[i] = filepath.Join(dir, exp[i])
+	}
+	if !equalStrings(files, exp) {
+		t.Errorf("expected files=%v; got %v", exp, files)
+	}
+}
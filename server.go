@@ -3,44 +3,94 @@ package fakerpc
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"math/rand"
 	"net"
 	"net/http"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 var errNoResponse = errors.New("fakerpc: no response recorded for the request")
 
-var noopReply = func(*net.TCPAddr, *net.TCPAddr, int64, error) {}
+var errFault = errors.New("fakerpc: injected fault")
+
+var errNoHijack = errors.New("fakerpc: unable to hijack the connection for a tunnel")
+
+var noopReply = func(net.Addr, net.Addr, int64, error) {}
 
 func write500(rw net.Conn, err error) {
+	writeStatus(rw, http.StatusInternalServerError, err)
+}
+
+func writeStatus(rw net.Conn, code int, err error) {
 	s := err.Error()
-	io.WriteString(rw, fmt.Sprintf("HTTP/1.1 500 Internal Server Error\r\n"+
+	io.WriteString(rw, fmt.Sprintf("HTTP/1.1 %d %s\r\n"+
 		"Content-Length: %d\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
-		len(s), s))
+		code, http.StatusText(code), len(s), s))
+}
+
+// A Matcher reports whether the recorded connection c is a match for the req
+// request with the given body.
+type Matcher func(req *http.Request, body []byte, c *Connection) bool
+
+// Matchers maps names of the matchers to their implementations.
+var Matchers = map[string]Matcher{
+	"request": MatchRequest,
+	"xml":     MatchXML,
+	"grpc":    MatchGRPC,
+}
+
+// A Fault describes failures a Server injects into its responses.
+type Fault struct {
+	// Rate is a probability of a response failing, from 0 to 1.
+	Rate float64 `json:"rate"`
+	// Status is a status code of a failed response. When 0, the connection
+	// (or the HTTP/2 stream) is reset instead.
+	Status int `json:"status,omitempty"`
 }
 
 // A Server represents a HTTP server, which serves connections by replying with
 // recorded responses.
 type Server struct {
 	// Reply function is called after each transmission is successfully completed.
-	Reply func(src, dst *net.TCPAddr, bodyLen int64, err error)
-	m     sync.Mutex
-	wg    sync.WaitGroup
-	wgr   sync.WaitGroup
-	conn  Connections
-	l     net.Listener
-	src   *net.TCPAddr
-	addr  string
-	isrun uint32
-	count int
-}
-
-// NewServer gives new Server for the given address and log.
+	// The addresses are *net.UnixAddr for Unix domain sockets and *net.TCPAddr
+	// otherwise.
+	Reply func(src, dst net.Addr, bodyLen int64, err error)
+	// Match function, when non-nil, is used to look up a response among recorded
+	// requests of a connection instead of replying with them in order.
+	Match Matcher
+	// TLSConfig, when non-nil, makes the Server serve TLS connections, which
+	// negotiate HTTP/2 or HTTP/1.1 via ALPN.
+	TLSConfig *tls.Config
+	// Latency delays every response by the given duration, plus a random one
+	// of up to Jitter.
+	Latency time.Duration
+	Jitter  time.Duration
+	// Fault, when non-nil, makes the Server fail a part of its responses.
+	Fault   *Fault
+	m       sync.Mutex
+	wg      sync.WaitGroup
+	wgr     sync.WaitGroup
+	conn    Connections
+	l       net.Listener
+	src     net.Addr
+	addr    string
+	isrun   uint32
+	count   int
+	active  map[net.Conn]*uint32      // served connections with their idle flags
+	h2      map[*http.Server]struct{} // servers of HTTP/2 connections
+	exit    chan struct{}             // closed when Serve returns
+	closing uint32                    // whether the srv is shutting down
+}
+
+// NewServer gives new Server for the given address and log. The addr prefixed
+// with "unix://", e.g. unix:///run/app.sock, is a path of a Unix domain socket.
 func NewServer(addr string, log *Log) (srv *Server, err error) {
 	srv = &Server{Reply: noopReply, addr: addr}
 	srv.wgr.Add(1)
@@ -49,21 +99,53 @@ func NewServer(addr string, log *Log) (srv *Server, err error) {
 }
 
 // ServeConn copies a response from c for every of the rw coonection's request.
+// HTTP/2 connections are served with responses matched per stream.
 func (srv *Server) ServeConn(rw net.Conn, c []Connection) {
+	var (
+		r   = bufio.NewReader(rw)
+		rem = rw.RemoteAddr()
+	)
+	h2, err := isHTTP2(rw, r)
+	if err == nil {
+		if h2 {
+			err = srv.serveHTTP2(rw, r, c, rem)
+		} else {
+			err = srv.serveHTTP1(rw, r, c, rem)
+		}
+	}
+	if err != nil && err != io.EOF {
+		srv.Reply(rem, srv.src, 0, err)
+	}
+	rw.Close()
+	srv.wg.Done()
+}
+
+func (srv *Server) serveHTTP1(rw net.Conn, r *bufio.Reader, c []Connection, rem net.Addr) (err error) {
 	var (
 		n   int64
-		err error
 		req *http.Request
-		r   = bufio.NewReader(rw)
-		rem = tcpaddrnil(rw.RemoteAddr())
 	)
+	used := make([]bool, len(c))
+	idle := srv.idle(rw)
 	for i := 0; ; i++ {
-		if req, err = http.ReadRequest(r); err != nil {
+		atomic.StoreUint32(idle, 1)
+		if atomic.LoadUint32(&srv.closing) == 1 {
+			break
+		}
+		req, err = http.ReadRequest(r)
+		atomic.StoreUint32(idle, 0)
+		if err != nil {
+			if atomic.LoadUint32(&srv.closing) == 1 {
+				// Read deadline of an idle connection was set by Shutdown.
+				err = nil
+			}
 			break
 		}
-		n, err = io.Copy(ioutil.Discard, req.Body)
+		var body bytes.Buffer
+		n, err = io.Copy(&body, req.Body)
 		req.Body.Close()
-		if i >= len(c) {
+		j := srv.match(req, body.Bytes(), c, used, i)
+		if j == -1 {
 			write500(rw, errNoResponse)
 			srv.Reply(rem, srv.src, n, errNoResponse)
 			continue
@@ -73,39 +155,153 @@ func (srv *Server) ServeConn(rw net.Conn, c []Connection) {
 			write500(rw, err)
 			continue
 		}
-		if c[i].Res != nil {
-			_, err = io.Copy(rw, bytes.NewBuffer(c[i].Res))
-			srv.Reply(srv.src, rem, int64(len(c[i].Res)), err)
+		if status := srv.inject(); status != 0 {
+			if status == -1 {
+				srv.Reply(srv.src, rem, 0, errFault)
+				abort(rw)
+				return nil
+			}
+			writeStatus(rw, status, errFault)
+			srv.Reply(srv.src, rem, 0, errFault)
+			continue
+		}
+		if res := c[j].Res; res != nil {
+			if c[j].Req.ProtoMajor == 2 {
+				res = downgrade(res, req)
+			}
+			if IsWebSocket(req) && c[j].Tunnel != nil {
+				res = wshandshake(res, req)
+			}
+			_, err = io.Copy(rw, bytes.NewBuffer(res))
+			srv.Reply(srv.src, rem, int64(len(res)), err)
+		}
+		if c[j].Tunnel != nil {
+			switch {
+			case req.Method == "CONNECT":
+				var calls []GobCall
+				if calls, err = c[j].GobCalls(); err == nil {
+					err = srv.serveGob(rw, r, calls, rem)
+				}
+			case IsWebSocket(req):
+				var frames []Frame
+				if frames, err = c[j].Frames(); err == nil {
+					err = srv.serveWebSocket(rw, r, frames, rem)
+				}
+			}
+			break
 		}
 	}
-	if err != nil && err != io.EOF {
-		srv.Reply(rem, srv.src, 0, err)
+	return
+}
+
+// idle gives the idle flag of the served connection rw.
+func (srv *Server) idle(rw net.Conn) *uint32 {
+	srv.m.Lock()
+	defer srv.m.Unlock()
+	if idle, ok := srv.active[rw]; ok {
+		return idle
 	}
-	rw.Close()
-	srv.wg.Done()
+	return new(uint32)
+}
+
+// inject delays a response by the srv's Latency and decides whether it fails.
+// It gives a status code of the failed response, -1 if the connection should
+// be reset instead or 0 if the response should be written as recorded.
+func (srv *Server) inject() int {
+	if d := srv.Latency; d > 0 || srv.Jitter > 0 {
+		if srv.Jitter > 0 {
+			d += time.Duration(rand.Int63n(int64(srv.Jitter)))
+		}
+		time.Sleep(d)
+	}
+	if srv.Fault == nil || rand.Float64() >= srv.Fault.Rate {
+		return 0
+	}
+	if srv.Fault.Status == 0 {
+		return -1
+	}
+	return srv.Fault.Status
+}
+
+// abort makes closing the rw reset the connection instead of shutting it down
+// gracefully, so the client notices it was aborted.
+func abort(rw net.Conn) {
+	if tc, ok := rw.(*tls.Conn); ok {
+		rw = tc.NetConn()
+	}
+	if tc, ok := rw.(*net.TCPConn); ok {
+		tc.SetLinger(0)
+	}
+}
+
+// match gives an index of the recorded connection, which is a reply for the
+// req or -1 if none was found. Requests recorded over HTTP/2 are looked up
+// with MatchGRPC when srv.Match is nil, as their order is not significant.
+func (srv *Server) match(req *http.Request, body []byte, c []Connection, used []bool, i int) int {
+	match := srv.Match
+	if match == nil {
+		if !multiplexed(c) {
+			if i < len(c) {
+				used[i] = true
+				return i
+			}
+			return -1
+		}
+		match = MatchGRPC
+	}
+	for j := range c {
+		if !used[j] && match(req, body, &c[j]) {
+			used[j] = true
+			return j
+		}
+	}
+	return -1
+}
+
+// multiplexed reports whether the c was recorded over HTTP/2.
+func multiplexed(c []Connection) bool {
+	for i := range c {
+		if c[i].Req.ProtoMajor == 2 {
+			return true
+		}
+	}
+	return false
 }
 
 // ListenAndServe starts the server which handles only specific number of
 // connections, determined by the Log argument given during creation of
 // the server; after those connections were served, Server stops itself.
-func (srv *Server) ListenAndServe() (err error) {
+func (srv *Server) ListenAndServe() error {
+	if atomic.LoadUint32(&srv.isrun) == 1 {
+		return ErrAlreadyRunning
+	}
+	l, err := net.Listen(splitaddr(srv.addr))
+	if err != nil {
+		return err
+	}
+	return srv.Serve(l)
+}
+
+// Serve is like ListenAndServe, but it accepts connections on the l, like an
+// in-memory listener or a pre-bound one, instead of listening on the srv's
+// address. The l is closed when Serve returns.
+func (srv *Server) Serve(l net.Listener) (err error) {
 	if atomic.CompareAndSwapUint32(&srv.isrun, 0, 1) {
 		srv.m.Lock()
+		srv.active, srv.exit = make(map[net.Conn]*uint32), make(chan struct{})
+		atomic.StoreUint32(&srv.closing, 0)
 		defer func() {
-			// Ignore "use of closed network connection" comming from closed
-			// net.Listener when p was explicitely stopped.
+			// Ignore "use of closed network connection" coming from closed
+			// net.Listener when srv was explicitly stopped.
 			if !atomic.CompareAndSwapUint32(&srv.isrun, 1, 0) {
 				err = nil
 			}
-		}()
-		if srv.l, err = net.Listen("tcp", srv.addr); err != nil {
-			srv.m.Unlock()
-			return
-		}
-		if srv.src, err = tcpaddr(srv.l.Addr()); err != nil {
+			srv.m.Lock()
+			close(srv.exit)
+			srv.exit = nil
 			srv.m.Unlock()
-			return
-		}
+		}()
+		srv.l, srv.src = l, l.Addr()
 		srv.wgr.Done()
 		srv.m.Unlock()
 		var (
@@ -116,10 +312,21 @@ func (srv *Server) ListenAndServe() (err error) {
 			if conn, err = srv.l.Accept(); err != nil {
 				return
 			}
+			if srv.TLSConfig != nil {
+				conn = tls.Server(conn, tlsconfig(srv.TLSConfig))
+			}
 			c = srv.conn[srv.count]
 			srv.count += 1
+			srv.m.Lock()
+			srv.active[conn] = new(uint32)
+			srv.m.Unlock()
 			srv.wg.Add(1)
-			go srv.ServeConn(conn, c)
+			go func(conn net.Conn, c []Connection) {
+				srv.ServeConn(conn, c)
+				srv.m.Lock()
+				delete(srv.active, conn)
+				srv.m.Unlock()
+			}(conn, c)
 			if srv.count == len(srv.conn) {
 				srv.Stop()
 				break
@@ -128,6 +335,7 @@ func (srv *Server) ListenAndServe() (err error) {
 		srv.wg.Wait()
 		return
 	}
+	l.Close()
 	return ErrAlreadyRunning
 }
 
@@ -153,3 +361,44 @@ func (srv *Server) Stop() (err error) {
 	}
 	return
 }
+
+// Shutdown stops the Server gracefully. It stops accepting new connections,
+// closes the idle ones and waits for the others to complete their exchanges.
+// HTTP/2 clients are sent GOAWAY, so their connections are closed once their
+// streams complete; tunnels are waited for until the client closes them.
+// If the ctx is done first, the remaining connections are closed forcibly and
+// the ctx's error is returned. Shutdown waits also for connections still served
+// after the Server stopped itself, having accepted all the recorded ones.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	if err := srv.Stop(); err != nil && err != ErrNotRunning {
+		return err
+	}
+	srv.m.Lock()
+	exit := srv.exit
+	if exit == nil {
+		srv.m.Unlock()
+		return ErrNotRunning
+	}
+	atomic.StoreUint32(&srv.closing, 1)
+	for conn, idle := range srv.active {
+		if atomic.LoadUint32(idle) == 1 {
+			conn.SetReadDeadline(time.Now())
+		}
+	}
+	for hs := range srv.h2 {
+		go hs.Shutdown(ctx)
+	}
+	srv.m.Unlock()
+	select {
+	case <-exit:
+		return nil
+	case <-ctx.Done():
+	}
+	srv.m.Lock()
+	for conn := range srv.active {
+		conn.Close()
+	}
+	srv.m.Unlock()
+	<-exit
+	return ctx.Err()
+}
@@ -0,0 +1,226 @@
+package fakerpc
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerShutdown(t *testing.T) {
+	l := calllog(
+		"GET /a HTTP/1.1\r\nHost: api.local\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n",
+		"GET /b HTTP/1.1\r\nHost: api.local\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n",
+	)
+	cases := [...]struct {
+		latency time.Duration // latency of the second response
+		timeout time.Duration
+		err     error
+	}{
+		{0, time.Second, nil},
+		{50 * time.Millisecond, time.Second, nil},
+		{300 * time.Millisecond, 20 * time.Millisecond, context.DeadlineExceeded},
+	}
+	for i, cas := range cases {
+		srv, err := NewServer("localhost:0", l)
+		if err != nil {
+			t.Fatalf("expected err=nil; got %q (i=%d)", err, i)
+		}
+		go srv.ListenAndServe()
+		tr := &http.Transport{}
+		client := &http.Client{Transport: tr}
+		addr := "http://" + srv.Addr().String()
+		res, err := client.Get(addr + "/a")
+		if err != nil {
+			t.Fatalf("expected err=nil; got %q (i=%d)", err, i)
+		}
+		res.Body.Close()
+		done := make(chan error, 1)
+		if cas.latency != 0 {
+			srv.Latency = cas.latency
+			go func() {
+				res, err := client.Get(addr + "/b")
+				if err == nil {
+					res.Body.Close()
+				}
+				done <- err
+			}()
+			time.Sleep(cas.latency / 5)
+		} else {
+			close(done)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), cas.timeout)
+		if err = srv.Shutdown(ctx); err != cas.err {
+			t.Errorf("expected err=%v; got %v (i=%d)", cas.err, err, i)
+		}
+		cancel()
+		if err = <-done; (err == nil) != (cas.err == nil) {
+			t.Errorf("expected the in-flight request to fail only on timeout; got %v (i=%d)", err, i)
+		}
+		tr.CloseIdleConnections()
+		if err = srv.Shutdown(context.Background()); err != ErrNotRunning {
+			t.Errorf("expected err=ErrNotRunning; got %v (i=%d)", err, i)
+		}
+	}
+}
+
+func TestServerShutdownHTTP2(t *testing.T) {
+	l := calllog(
+		"GET /a HTTP/1.1\r\nHost: api.local\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\na",
+	)
+	srv, err := NewServer("localhost:0", l)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	go srv.ListenAndServe()
+	tr := &http.Transport{Protocols: h2cprotos}
+	defer tr.CloseIdleConnections()
+	res, err := (&http.Client{Transport: tr}).Get("http://" + srv.Addr().String() + "/a")
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	res.Body.Close()
+	if res.ProtoMajor != 2 {
+		t.Fatalf("expected HTTP/2 response; got %s", res.Proto)
+	}
+	// The connection is left idle, like the ones of gRPC clients.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = srv.Shutdown(ctx); err != nil {
+		t.Errorf("expected err=nil; got %q", err)
+	}
+}
+
+func TestServerServe(t *testing.T) {
+	l := calllog(
+		"GET /a HTTP/1.1\r\nHost: api.local\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\na",
+	)
+	srv, err := NewServer("", l)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(lis)
+	}()
+	tr := &http.Transport{}
+	res, err := (&http.Client{Transport: tr}).Get("http://" + lis.Addr().String() + "/a")
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	p, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil || string(p) != "a" {
+		t.Errorf("expected body=a, err=nil; got %q, %v", p, err)
+	}
+	tr.CloseIdleConnections()
+	if err = <-done; err != nil {
+		t.Errorf("expected err=nil; got %q", err)
+	}
+}
+
+func TestServerHandler(t *testing.T) {
+	l := calllog(
+		"GET /a HTTP/1.1\r\nHost: api.local\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\na",
+		"POST /b HTTP/1.1\r\nHost: api.local\r\nContent-Length: 3\r\n\r\nreq",
+		"HTTP/1.1 201 Created\r\nContent-Length: 1\r\n\r\nb",
+	)
+	srv, err := NewServer("", l)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	replies := make(chan error, 8)
+	srv.Reply = func(_, _ net.Addr, _ int64, err error) {
+		replies <- err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", srv.Handler())
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	cases := [...]struct {
+		method, path, body string
+		status             int
+		res                string
+		errs               []error
+	}{
+		{"POST", "/b", "req", 201, "b", []error{nil, nil}},
+		{"GET", "/a", "", 200, "a", []error{nil, nil}},
+		{"GET", "/a", "", 500, errNoResponse.Error() + "\n", []error{errNoResponse}},
+		{"POST", "/b", "other", 500, errNoResponse.Error() + "\n", []error{errNoResponse}},
+	}
+	for i, cas := range cases {
+		req, err := http.NewRequest(cas.method, ts.URL+cas.path, strings.NewReader(cas.body))
+		if err != nil {
+			t.Fatalf("expected err=nil; got %q (i=%d)", err, i)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("expected err=nil; got %q (i=%d)", err, i)
+		}
+		p, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("expected err=nil; got %q (i=%d)", err, i)
+		}
+		if res.StatusCode != cas.status {
+			t.Errorf("expected StatusCode=%d; got %d (i=%d)", cas.status, res.StatusCode, i)
+		}
+		if string(p) != cas.res {
+			t.Errorf("expected body=%q; got %q (i=%d)", cas.res, p, i)
+		}
+		for j, exp := range cas.errs {
+			if err := <-replies; err != exp {
+				t.Errorf("expected reply err=%v; got %v (i=%d, j=%d)", exp, err, i, j)
+			}
+		}
+	}
+	// Clients of a handler served over a Unix domain socket have Unix addresses.
+	dir, err := ioutil.TempDir("", "fakerpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lis, err := net.Listen("unix", filepath.Join(dir, "srv.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	if srv, err = NewServer("", l); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	rem := make(chan net.Addr, 1)
+	srv.Reply = func(src, _ net.Addr, _ int64, _ error) {
+		select {
+		case rem <- src:
+		default:
+		}
+	}
+	go http.Serve(lis, srv.Handler())
+	client, tr := unixclient(lis.Addr().String())
+	res, err := client.Get("http://fake/a")
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	res.Body.Close()
+	tr.CloseIdleConnections()
+	if src := <-rem; src == nil {
+		t.Error("expected client address to be non-nil")
+	} else if _, ok := src.(*net.UnixAddr); !ok {
+		t.Errorf("expected client address to be a Unix one; got %T", src)
+	}
+}
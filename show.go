@@ -0,0 +1,241 @@
+package fakerpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A CallView is a single request/response pair of a log prepared for being
+// shown, either rendered by String or encoded as JSON. Bodies, which are not
+// a text, are encoded in the Body64 and ResBody64 fields instead of the Body
+// and ResBody ones.
+type CallView struct {
+	Conn          int         `json:"conn"`
+	Src           string      `json:"src"`
+	Dst           string      `json:"dst"`
+	Method        string      `json:"method"`
+	URI           string      `json:"uri"`
+	Proto         string      `json:"proto"`
+	Host          string      `json:"host,omitempty"`
+	Header        http.Header `json:"header,omitempty"`
+	Body          *string     `json:"body,omitempty"`
+	Body64        []byte      `json:"body64,omitempty"`
+	Status        int         `json:"status,omitempty"`
+	ResProto      string      `json:"responseProto,omitempty"`
+	ResHeader     http.Header `json:"responseHeader,omitempty"`
+	ResBody       *string     `json:"responseBody,omitempty"`
+	ResBody64     []byte      `json:"responseBody64,omitempty"`
+	Tunnel        int         `json:"tunnel,omitempty"`
+	Err           string      `json:"error,omitempty"`
+	status        string
+	body, resBody []byte
+}
+
+// setBody sets the text or the binary field to the p.
+func setBody(p []byte, text **string, bin *[]byte) {
+	switch {
+	case len(p) == 0:
+	case printable(p):
+		s := string(p)
+		*text = &s
+	default:
+		*bin = p
+	}
+}
+
+// A CallFilter selects calls of a log to be shown. A zero CallFilter selects
+// all of them.
+type CallFilter struct {
+	// Conns is a list of indices of the connections, as given by Log.Conns,
+	// the calls are selected from. All connections are used if it's empty.
+	Conns []int
+	// Path, when non-empty, is a pattern the URL path of a request must
+	// match; see ByPath.
+	Path string
+	// Methods, when non-empty, is a list of accepted request methods.
+	Methods []string
+	// Status, when non-zero, is a status code the response must have.
+	Status int
+}
+
+// Calls gives views of calls of the l, which match the f, ordered by
+// connections. A connection, which calls can't be read, is given as a single
+// view with the Err field set.
+func (f *CallFilter) Calls(l *Log) []CallView {
+	calls := make([]CallView, 0)
+	for i, conn := range l.Conns() {
+		if !f.conn(i) {
+			continue
+		}
+		src, dst := conn.T[0].Src.String(), conn.T[0].Dst.String()
+		if f.Path != "" {
+			conn = conn.Where(ByPath(f.Path))
+		}
+		if len(f.Methods) != 0 {
+			conn = conn.Where(ByMethod(f.Methods...))
+		}
+		if len(conn.T) == 0 {
+			continue
+		}
+		c, err := NewConnections(conn)
+		if err != nil {
+			calls = append(calls, CallView{Conn: i, Src: src, Dst: dst, Err: err.Error()})
+			continue
+		}
+		for _, c := range c[0] {
+			v := CallView{
+				Conn:   i,
+				Src:    src,
+				Dst:    dst,
+				Method: c.Req.Method,
+				URI:    c.Req.RequestURI,
+				Proto:  c.Req.Proto,
+				Host:   c.Req.Host,
+				Header: c.Req.Header,
+				Tunnel: len(c.Tunnel),
+				body:   c.Body(),
+			}
+			setBody(v.body, &v.Body, &v.Body64)
+			if res, p, err := c.Response(); err == nil {
+				v.Status, v.status, v.ResProto, v.ResHeader = res.StatusCode, res.Status, res.Proto, res.Header
+				v.resBody = p
+				setBody(p, &v.ResBody, &v.ResBody64)
+			} else {
+				v.Err = err.Error()
+			}
+			if f.Status != 0 && f.Status != v.Status {
+				continue
+			}
+			calls = append(calls, v)
+		}
+	}
+	return calls
+}
+
+// conn reports whether calls of the i-th connection are selected.
+func (f *CallFilter) conn(i int) bool {
+	if len(f.Conns) == 0 {
+		return true
+	}
+	for _, n := range f.Conns {
+		if n == i {
+			return true
+		}
+	}
+	return false
+}
+
+// String gives a human-readable representation of the c. JSON and XML bodies
+// are pretty-printed, other ones are given as is if they're a text or as
+// a hex dump otherwise.
+func (c *CallView) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s %s %s\n", c.Method, c.URI, c.Proto)
+	if c.Host != "" {
+		fmt.Fprintf(&buf, "Host: %s\n", c.Host)
+	}
+	renderHeader(&buf, c.Header)
+	renderBody(&buf, c.body, c.Header.Get("Content-Type"))
+	if c.status != "" {
+		fmt.Fprintf(&buf, "--- %s %s\n", c.ResProto, c.status)
+		renderHeader(&buf, c.ResHeader)
+		renderBody(&buf, c.resBody, c.ResHeader.Get("Content-Type"))
+	}
+	if c.Tunnel != 0 {
+		fmt.Fprintf(&buf, "--- (%d tunnelled transmissions)\n", c.Tunnel)
+	}
+	if c.Err != "" {
+		fmt.Fprintf(&buf, "--- error: %s\n", c.Err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func renderHeader(w io.Writer, h http.Header) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			fmt.Fprintf(w, "%s: %s\n", k, v)
+		}
+	}
+}
+
+// renderBody writes the p pretty-printed if it's a JSON or XML document, as
+// is if it's a text or as a hex dump otherwise.
+func renderBody(w io.Writer, p []byte, ctype string) {
+	if len(p) == 0 {
+		return
+	}
+	io.WriteString(w, "\n"+pretty(p, ctype))
+}
+
+func pretty(p []byte, ctype string) string {
+	var buf bytes.Buffer
+	if json.Indent(&buf, p, "", "  ") == nil {
+		return buf.String() + "\n"
+	}
+	if x, err := ParseXMLCall(p); err == nil {
+		return x.String() + "\n"
+	}
+	if buf.Reset(); bytes.HasPrefix(bytes.TrimSpace(p), []byte("<")) && xmlIndent(&buf, p) == nil {
+		return buf.String()
+	}
+	if printable(p) && !strings.HasPrefix(ctype, "application/octet-stream") {
+		if p[len(p)-1] != '\n' {
+			return string(p) + "\n"
+		}
+		return string(p)
+	}
+	return hex.Dump(p)
+}
+
+// printable reports whether the p is a text.
+func printable(p []byte) bool {
+	if !utf8.Valid(p) {
+		return false
+	}
+	for _, r := range string(p) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// xmlIndent writes the XML document p indented to the w.
+func xmlIndent(w io.Writer, p []byte) error {
+	dec, enc := xml.NewDecoder(bytes.NewReader(p)), xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if cd, ok := tok.(xml.CharData); ok && len(bytes.TrimSpace(cd)) == 0 {
+			continue
+		}
+		if err = enc.EncodeToken(xml.CopyToken(tok)); err != nil {
+			return err
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
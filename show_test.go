@@ -0,0 +1,101 @@
+package fakerpc
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+var showlog = &Log{T: []Transmission{{
+	Src: &cli[0], Dst: srv,
+	Raw: []byte("GET /a HTTP/1.1\r\nHost: api.local\r\n\r\n"),
+}, {
+	Src: srv, Dst: &cli[0],
+	Raw: []byte("HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: 13\r\n\r\n" +
+		`{"a":1,"b":2}`),
+}, {
+	Src: &cli[0], Dst: srv,
+	Raw: []byte("POST /b HTTP/1.1\r\nContent-Type: application/octet-stream\r\nContent-Length: 3\r\n\r\n\x00\x01\x02"),
+}, {
+	Src: srv, Dst: &cli[0],
+	Raw: []byte("HTTP/1.1 201 Created\r\nContent-Length: 2\r\n\r\nok"),
+}, {
+	Src: &cli[1], Dst: srv,
+	Raw: []byte("GET /a/x HTTP/1.1\r\n\r\n"),
+}, {
+	Src: srv, Dst: &cli[1],
+	Raw: []byte("HTTP/1.1 404 Not Found\r\nContent-Length: 2\r\n\r\nno"),
+}}}
+
+func TestCallFilter(t *testing.T) {
+	cases := [...]struct {
+		f   CallFilter
+		exp []string
+	}{
+		{CallFilter{}, []string{"0 GET /a 200", "0 POST /b 201", "1 GET /a/x 404"}},
+		{CallFilter{Conns: []int{1}}, []string{"1 GET /a/x 404"}},
+		{CallFilter{Conns: []int{0, 1}}, []string{"0 GET /a 200", "0 POST /b 201", "1 GET /a/x 404"}},
+		{CallFilter{Path: "/a"}, []string{"0 GET /a 200"}},
+		{CallFilter{Path: "/a/*"}, []string{"1 GET /a/x 404"}},
+		{CallFilter{Methods: []string{"post", "PUT"}}, []string{"0 POST /b 201"}},
+		{CallFilter{Status: 404}, []string{"1 GET /a/x 404"}},
+		{CallFilter{Conns: []int{0}, Status: 404}, []string{}},
+		{CallFilter{Conns: []int{2}}, []string{}},
+	}
+	for i, cas := range cases {
+		calls := cas.f.Calls(showlog)
+		got := make([]string, 0, len(calls))
+		for _, c := range calls {
+			got = append(got, fmt.Sprintf("%d %s %s %d", c.Conn, c.Method, c.URI, c.Status))
+		}
+		if !reflect.DeepEqual(got, cas.exp) {
+			t.Errorf("expected calls=%v; got %v (i=%d)", cas.exp, got, i)
+		}
+	}
+}
+
+func TestCallViewString(t *testing.T) {
+	calls := (&CallFilter{}).Calls(showlog)
+	if len(calls) != 3 {
+		t.Fatalf("expected len(calls)=3; got %d", len(calls))
+	}
+	cases := [...]struct {
+		body, res string
+		body64    []byte
+		exp       string
+	}{{
+		"", "{\"a\":1,\"b\":2}", nil,
+		"--- GET /a HTTP/1.1\nHost: api.local\n--- HTTP/1.1 200 OK\nContent-Length: 13\n" +
+			"Content-Type: application/json\n\n{\n  \"a\": 1,\n  \"b\": 2\n}",
+	}, {
+		"", "ok", []byte{0, 1, 2},
+		"--- POST /b HTTP/1.1\nContent-Length: 3\nContent-Type: application/octet-stream\n\n" +
+			"00000000  00 01 02                                          |...|\n" +
+			"--- HTTP/1.1 201 Created\nContent-Length: 2\n\nok",
+	}, {
+		"", "no", nil,
+		"--- GET /a/x HTTP/1.1\n--- HTTP/1.1 404 Not Found\nContent-Length: 2\n\nno",
+	}}
+	for i, cas := range cases {
+		c := calls[i]
+		if s := c.String(); s != cas.exp {
+			t.Errorf("expected String()=%q; got %q (i=%d)", cas.exp, s, i)
+		}
+		if body := str(c.Body); body != cas.body {
+			t.Errorf("expected Body=%q; got %q (i=%d)", cas.body, body, i)
+		}
+		if res := str(c.ResBody); res != cas.res {
+			t.Errorf("expected ResBody=%q; got %q (i=%d)", cas.res, res, i)
+		}
+		if !reflect.DeepEqual(c.Body64, cas.body64) {
+			t.Errorf("expected Body64=%v; got %v (i=%d)", cas.body64, c.Body64, i)
+		}
+	}
+}
+
+func str(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
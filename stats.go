@@ -0,0 +1,126 @@
+package fakerpc
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"sort"
+)
+
+// A Dist summarizes a distribution of values, either sizes in bytes or
+// durations in nanoseconds.
+type Dist struct {
+	N    int   // number of values
+	Min  int64 // the smallest value
+	P50  int64 // the median
+	P90  int64 // the 90th percentile
+	P99  int64 // the 99th percentile
+	Max  int64 // the largest value
+	vals []int64
+}
+
+func (d *Dist) add(v int64) {
+	d.vals = append(d.vals, v)
+}
+
+// done computes the percentiles with the nearest-rank method.
+func (d *Dist) done() {
+	if d.N = len(d.vals); d.N == 0 {
+		return
+	}
+	sort.Slice(d.vals, func(i, j int) bool { return d.vals[i] < d.vals[j] })
+	rank := func(p int) int64 {
+		return d.vals[(p*d.N+99)/100-1]
+	}
+	d.Min, d.P50, d.P90, d.P99, d.Max = d.vals[0], rank(50), rank(90), rank(99), d.vals[d.N-1]
+	d.vals = nil
+}
+
+// An Endpoint summarizes calls of a single method and URL path.
+type Endpoint struct {
+	Method  string
+	Path    string
+	Calls   int
+	ReqSize Dist // sizes of raw requests
+	ResSize Dist // sizes of raw responses
+	Latency Dist // time between a request and its response, if recorded
+}
+
+// Stats summarizes a Log.
+type Stats struct {
+	Conns     int         // number of TCP connections
+	Requests  int         // number of requests
+	Bytes     int64       // number of bytes of all the transmissions
+	Status    map[int]int // number of responses per status code; 0 counts requests without a valid one
+	Endpoints []Endpoint  // calls per endpoint, the most called first
+	ReqSize   Dist        // sizes of raw requests
+	ResSize   Dist        // sizes of raw responses
+	Latency   Dist        // time between a request and its response, if recorded
+}
+
+// Summarize gives Stats of the l. Requests, which can't be parsed, are
+// counted, but they're not accounted to any endpoint. Latency is computed from
+// times of transmissions, so it's known for logs recorded by a Proxy only.
+func Summarize(l *Log) *Stats {
+	s := &Stats{Status: make(map[int]int)}
+	if l == nil || len(l.T) == 0 {
+		return s
+	}
+	s.Conns = len(l.Conns())
+	for _, t := range l.T {
+		s.Bytes += int64(len(t.Raw))
+	}
+	index := make(map[string]int)
+	for _, e := range exchanges(l) {
+		if bytes.HasPrefix(l.T[e.req].Raw, []byte("HTTP/")) {
+			continue
+		}
+		s.Requests++
+		var ep *Endpoint
+		header, _ := SplitHeaderBody(l.T[e.req].Raw)
+		if req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(header))); err == nil {
+			key := req.Method + " " + req.URL.Path
+			n, ok := index[key]
+			if !ok {
+				s.Endpoints = append(s.Endpoints, Endpoint{Method: req.Method, Path: req.URL.Path})
+				n = len(s.Endpoints) - 1
+				index[key] = n
+			}
+			ep = &s.Endpoints[n]
+			ep.Calls++
+			ep.ReqSize.add(int64(len(l.T[e.req].Raw)))
+		}
+		s.ReqSize.add(int64(len(l.T[e.req].Raw)))
+		if e.res == -1 {
+			s.Status[0]++
+			continue
+		}
+		res := l.T[e.res]
+		s.Status[statusCode(res.Raw)]++
+		s.ResSize.add(int64(len(res.Raw)))
+		req := l.T[e.req]
+		timed := !req.Time.IsZero() && !res.Time.IsZero()
+		if timed {
+			s.Latency.add(int64(res.Time.Sub(req.Time)))
+		}
+		if ep != nil {
+			ep.ResSize.add(int64(len(res.Raw)))
+			if timed {
+				ep.Latency.add(int64(res.Time.Sub(req.Time)))
+			}
+		}
+	}
+	for i := range s.Endpoints {
+		ep := &s.Endpoints[i]
+		ep.ReqSize.done()
+		ep.ResSize.done()
+		ep.Latency.done()
+	}
+	sort.SliceStable(s.Endpoints, func(i, j int) bool {
+		return s.Endpoints[i].Calls > s.Endpoints[j].Calls
+	})
+	s.ReqSize.done()
+	s.ResSize.done()
+	s.Latency.done()
+	return s
+}
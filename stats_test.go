@@ -0,0 +1,54 @@
+package fakerpc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	l := calllog(
+		"GET /a?x=1 HTTP/1.1\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n",
+		"GET /a?x=2 HTTP/1.1\r\n\r\n",
+		"HTTP/1.1 404 Not Found\r\nContent-Length: 2\r\n\r\nNO",
+		"POST /b HTTP/1.1\r\nContent-Length: 2\r\n\r\nOK",
+		"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n",
+	)
+	base := time.Unix(1400000000, 0)
+	for i := range l.T {
+		l.T[i].Time = base.Add(time.Duration(i*i) * time.Millisecond)
+	}
+	l.T = append(l.T, Transmission{Src: &cli[1], Dst: srv, Raw: []byte("GET /a HTTP/1.1\r\n\r\n")})
+	s := Summarize(l)
+	if s.Conns != 2 || s.Requests != 4 {
+		t.Errorf("expected Conns=2, Requests=4; got %d, %d", s.Conns, s.Requests)
+	}
+	if exp := map[int]int{0: 1, 200: 2, 404: 1}; !reflect.DeepEqual(s.Status, exp) {
+		t.Errorf("expected Status=%v; got %v", exp, s.Status)
+	}
+	if len(s.Endpoints) != 2 {
+		t.Fatalf("expected len(Endpoints)=2; got %d", len(s.Endpoints))
+	}
+	a, b := s.Endpoints[0], s.Endpoints[1]
+	if a.Method != "GET" || a.Path != "/a" || a.Calls != 3 || b.Path != "/b" || b.Calls != 1 {
+		t.Errorf("expected GET /a (3), POST /b (1); got %s %s (%d), %s %s (%d)", a.Method, a.Path,
+			a.Calls, b.Method, b.Path, b.Calls)
+	}
+	// Latencies are 1ms, 5ms and 9ms.
+	exp := Dist{N: 3, Min: int64(time.Millisecond), P50: int64(5 * time.Millisecond),
+		P90: int64(9 * time.Millisecond), P99: int64(9 * time.Millisecond), Max: int64(9 * time.Millisecond)}
+	if !reflect.DeepEqual(s.Latency, exp) {
+		t.Errorf("expected Latency=%+v; got %+v", exp, s.Latency)
+	}
+	if a.Latency.N != 2 || a.ResSize.N != 2 || a.ReqSize.N != 3 {
+		t.Errorf("expected Latency.N=2, ResSize.N=2, ReqSize.N=3; got %d, %d, %d", a.Latency.N,
+			a.ResSize.N, a.ReqSize.N)
+	}
+	if s.ResSize.Min != 38 || s.ResSize.Max != 47 {
+		t.Errorf("expected ResSize.Min=38, ResSize.Max=47; got %d, %d", s.ResSize.Min, s.ResSize.Max)
+	}
+	if s := Summarize(NewLog()); s.Requests != 0 || len(s.Status) != 0 {
+		t.Errorf("expected empty stats; got %+v", s)
+	}
+}
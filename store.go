@@ -0,0 +1,108 @@
+package fakerpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// BodyDir is a name of the directory, which keeps bodies cut off logs written
+// by WriteLogStore. It's looked up next to the log file, so all logs within
+// a single directory, e.g. testdata, share their bodies.
+const BodyDir = "bodies"
+
+// A BodyStore keeps bodies by their content.
+type BodyStore interface {
+	// Put stores the body, giving a key it can be got back with. Putting
+	// the same body twice gives the same key and stores it once.
+	Put(body []byte) (key string, err error)
+	// Get gives a body stored under the key.
+	Get(key string) ([]byte, error)
+}
+
+// A DirStore is a BodyStore, which keeps every body gzipped in a separate file
+// within the directory. Keys are hex-encoded SHA-256 hashes of bodies.
+type DirStore string
+
+// StoreFor gives a DirStore for the log file.
+func StoreFor(file string) DirStore {
+	return DirStore(filepath.Join(filepath.Dir(file), BodyDir))
+}
+
+func (ds DirStore) path(key string) string {
+	return filepath.Join(string(ds), key+".gz")
+}
+
+// Put implements the BodyStore interface.
+func (ds DirStore) Put(body []byte) (string, error) {
+	sum := sha256.Sum256(body)
+	key := hex.EncodeToString(sum[:])
+	if _, err := os.Stat(ds.path(key)); err == nil {
+		return key, nil
+	}
+	if err := os.MkdirAll(string(ds), 0755); err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(string(ds), key)
+	if err != nil {
+		return "", err
+	}
+	gz, err := gzip.NewWriterLevel(f, gzip.BestCompression)
+	if err == nil {
+		if _, err = gz.Write(body); err == nil {
+			err = gz.Close()
+		}
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	// Renaming makes concurrent writers of the same body safe.
+	if err == nil {
+		err = os.Rename(f.Name(), ds.path(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return key, nil
+}
+
+// Get implements the BodyStore interface.
+func (ds DirStore) Get(key string) ([]byte, error) {
+	p, err := ioutil.ReadFile(ds.path(key))
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(p))
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(gz)
+	if err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(body); hex.EncodeToString(sum[:]) != key {
+		return nil, fmt.Errorf("fakerpc: body %s is corrupted", key)
+	}
+	return body, nil
+}
+
+// WriteLogStore writes the Log to the file like WriteLog, but keeps bodies of
+// at least threshold bytes out of the log, in the BodyDir directory next to
+// the file. ReadLog reads such logs transparently.
+func WriteLogStore(file string, l *Log, threshold int) error {
+	return writeAtomic(file, func(w io.Writer) error {
+		lw := NewLogWriter(w)
+		lw.Store, lw.Threshold = StoreFor(file), threshold
+		if err := lw.WriteHeader(l.Networks, l.Filter); err != nil {
+			return err
+		}
+		return lw.Write(l.T)
+	})
+}
@@ -0,0 +1,91 @@
+package fakerpc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteLogStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakerpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	body := bytes.Repeat([]byte("schema"), 1024)
+	l := &Log{T: []Transmission{{
+		Src: &cli[0], Dst: srv,
+		Raw: []byte("GET /schema HTTP/1.1\r\n\r\n"),
+	}, {
+		Src: srv, Dst: &cli[0],
+		Raw: append([]byte("HTTP/1.1 200 OK\r\nContent-Length: 6144\r\n\r\n"), body...),
+	}, {
+		Src: &cli[0], Dst: srv,
+		Raw: append([]byte("POST /schema HTTP/1.1\r\nContent-Length: 6144\r\n\r\n"), body...),
+	}, {
+		Src: srv, Dst: &cli[0],
+		Raw: []byte("HTTP/1.1 204 No Content\r\n\r\n"),
+	}}}
+	files := []string{filepath.Join(dir, "a.gzob"), filepath.Join(dir, "b.gzob")}
+	for _, file := range files {
+		if err = WriteLogStore(file, l, 1024); err != nil {
+			t.Fatalf("expected err=nil; got %q", err)
+		}
+		fi, err := os.Stat(file)
+		if err != nil {
+			t.Fatalf("expected err=nil; got %q", err)
+		}
+		if fi.Size() > 1024 {
+			t.Errorf("expected bodies to be kept out of %s; its size is %d", file, fi.Size())
+		}
+	}
+	stored, err := ioutil.ReadDir(filepath.Join(dir, BodyDir))
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if len(stored) != 1 {
+		t.Errorf("expected single stored body; got %d", len(stored))
+	}
+	for _, file := range files {
+		rl, err := ReadLog(file)
+		if err != nil {
+			t.Fatalf("expected err=nil; got %q", err)
+		}
+		for i := range l.T {
+			if !bytes.Equal(rl.T[i].Raw, l.T[i].Raw) {
+				t.Errorf("expected rl.T[%d].Raw=%q; got %q (file=%s)", i, l.T[i].Raw, rl.T[i].Raw, file)
+			}
+		}
+	}
+	rl, lf, err := ReadLogFile(files[0])
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if lf.Threshold != len(body) {
+		t.Errorf("expected lf.Threshold=%d; got %d", len(body), lf.Threshold)
+	}
+	if err = lf.WriteLog(files[0], rl); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	fi, err := os.Stat(files[0])
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if fi.Size() > 1024 {
+		t.Errorf("expected rewritten %s to keep bodies out; its size is %d", files[0], fi.Size())
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, _, err = DecodeLog(f); err == nil {
+		t.Error("expected err!=nil when decoding a log without its store")
+	}
+	os.RemoveAll(filepath.Join(dir, BodyDir))
+	if _, err = ReadLog(files[1]); err == nil {
+		t.Error("expected err!=nil when reading a log with missing bodies")
+	}
+}
@@ -1,10 +1,18 @@
 package fakerpc
 
 import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
 	"errors"
+	"io"
+	"io/ioutil"
 	"net"
+	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 )
 
 func ipnil(ip net.IP) net.IP {
@@ -58,15 +66,68 @@ func tcpaddr(addr net.Addr) (*net.TCPAddr, error) {
 	return tcpa, nil
 }
 
-func tcpaddrnil(addr net.Addr) (tcpa *net.TCPAddr) {
-	if a, err := tcpaddr(addr); err == nil {
-		tcpa = a
+// addrequal reports whether the lhs and rhs are the same end point; TCP
+// addresses are compared by their IPs and ports.
+func addrequal(lhs, rhs net.Addr) bool {
+	if lhs == nil || rhs == nil {
+		return lhs == rhs
 	}
-	return
+	l, lok := lhs.(*net.TCPAddr)
+	r, rok := rhs.(*net.TCPAddr)
+	if lok && rok {
+		return l == r || (l.IP.Equal(r.IP) && l.Port == r.Port)
+	}
+	return lhs.Network() == rhs.Network() && lhs.String() == rhs.String()
+}
+
+// unixPrefix begins addresses of Unix domain sockets, like unix:///run/app.sock.
+const unixPrefix = "unix://"
+
+// splitaddr gives a network and an address for net.Listen or net.Dial - "unix"
+// and a socket path for an addr with the unixPrefix, "tcp" and the addr
+// otherwise.
+func splitaddr(addr string) (network, address string) {
+	if strings.HasPrefix(addr, unixPrefix) {
+		return "unix", addr[len(unixPrefix):]
+	}
+	return "tcp", addr
+}
+
+// addrstring gives the addr as a string, which parsenetaddr understands.
+func addrstring(addr net.Addr) string {
+	if ua, ok := addr.(*net.UnixAddr); ok {
+		return unixPrefix + ua.Name
+	}
+	return addr.String()
+}
+
+// parsenetaddr gives either a Unix address for the s with the unixPrefix or
+// a TCP one for the "ip:port" s.
+func parsenetaddr(s string) (net.Addr, error) {
+	if strings.HasPrefix(s, unixPrefix) {
+		return &net.UnixAddr{Name: s[len(unixPrefix):], Net: "unix"}, nil
+	}
+	addr, err := parseAddr(s)
+	if err != nil {
+		return nil, err
+	}
+	return addr, nil
+}
+
+// unixpeer gives an address identifying the n-th client connection of a Unix
+// socket, as clients usually connect from unnamed sockets.
+func unixpeer(n uint32) *net.UnixAddr {
+	return &net.UnixAddr{Name: "@" + strconv.FormatUint(uint64(n), 10), Net: "unix"}
 }
 
-func tcpaddrequal(lhs, rhs *net.TCPAddr) bool {
-	return lhs == rhs || (lhs.IP.Equal(rhs.IP) && lhs.Port == rhs.Port)
+// istcp reports whether the addr is a TCP address, either a *net.TCPAddr or
+// a "host:port" one.
+func istcp(addr net.Addr) bool {
+	if _, ok := addr.(*net.UnixAddr); ok {
+		return false
+	}
+	_, err := tcpaddr(addr)
+	return err == nil
 }
 
 func ipnetaddr(addr net.Addr) ([]*net.IPNet, error) {
@@ -104,10 +165,75 @@ type hpwrap string
 func (w hpwrap) Network() string { return string(w) }
 func (w hpwrap) String() string  { return string(w) }
 
+// urltoaddr gives an address of the u, which is a Unix one for the "unix"
+// scheme.
+func urltoaddr(u *url.URL) (net.Addr, error) {
+	if u.Scheme == "unix" {
+		return &net.UnixAddr{Name: u.Path, Net: "unix"}, nil
+	}
+	addr, err := urltotcpaddr(u)
+	if err != nil {
+		return nil, err
+	}
+	return addr, nil
+}
+
 func urltotcpaddr(u *url.URL) (*net.TCPAddr, error) {
 	hp := u.Host
 	if _, _, err := net.SplitHostPort(hp); err != nil {
-		hp = hp + ":80"
+		if u.Scheme == "https" {
+			hp = hp + ":443"
+		} else {
+			hp = hp + ":80"
+		}
 	}
 	return tcpaddr(hpwrap(hp))
 }
+
+func statusCode(res []byte) int {
+	r, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(res)), nil)
+	if err != nil {
+		return 0
+	}
+	r.Body.Close()
+	return r.StatusCode
+}
+
+// readResponse parses the raw response for the req, giving its body decoded
+// according to the Content-Encoding. A body shorter than its Content-Length is
+// given as recorded.
+func readResponse(raw []byte, req *http.Request) (*http.Response, []byte, error) {
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), req)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, nil, err
+	}
+	return res, decodeBody(body, res.Header.Get("Content-Encoding")), nil
+}
+
+// decodeBody gives the body decompressed according to the encoding, or
+// the body itself if it's not compressed or can't be decompressed.
+func decodeBody(body []byte, encoding string) []byte {
+	var r io.Reader
+	switch encoding {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return body
+		}
+		r = gz
+	case "deflate":
+		r = flate.NewReader(bytes.NewReader(body))
+	default:
+		return body
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		return body
+	}
+	return p
+}
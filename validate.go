@@ -0,0 +1,145 @@
+package fakerpc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// An Issue describes a single problem found in a Log by Validate.
+type Issue struct {
+	Index int      // index of the transmission or -1 if the issue concerns whole log
+	Addr  net.Addr // source address of the transmission
+	Err   error    // description of the problem
+}
+
+func (i Issue) String() string {
+	if i.Index == -1 {
+		return i.Err.Error()
+	}
+	return fmt.Sprintf("T[%d] %v: %v", i.Index, i.Addr, i.Err)
+}
+
+var (
+	errEmptyLog    = errors.New("fakerpc: log is either nil or empty")
+	errMissingAddr = errors.New("fakerpc: missing source or destination address")
+	errNoRequest   = errors.New("fakerpc: response without request")
+	errInterleaved = errors.New("fakerpc: response separated from its request by other connection")
+	errShortBody   = errors.New("fakerpc: recorded body length is too small")
+	errExcessBody  = errors.New("fakerpc: recorded body exceeds Content-Length")
+	errHeaderEnd   = errors.New("fakerpc: unable to find end of the header")
+)
+
+// Validate checks the l for problems, which make requests unable to replay, or
+// replay differently than they were recorded. Unlike NewConnections, which
+// fails on the first problem, it reports every issue found.
+func Validate(l *Log) []Issue {
+	if l == nil || len(l.T) == 0 {
+		return []Issue{{Index: -1, Err: errEmptyLog}}
+	}
+	var issues []Issue
+	report := func(i int, err error) {
+		issues = append(issues, Issue{Index: i, Addr: l.T[i].Src, Err: err})
+	}
+	for i, t := range l.T {
+		if t.Src == nil || t.Dst == nil {
+			report(i, errMissingAddr)
+		}
+	}
+	if len(issues) != 0 {
+		// Requests can't be paired with responses without addresses.
+		return issues
+	}
+	// Responses of interleaved requests are reported along with the requests.
+	orphans := make(map[int]struct{})
+	for _, e := range exchanges(l) {
+		if bytes.HasPrefix(l.T[e.req].Raw, []byte("HTTP/")) {
+			if _, ok := orphans[e.req]; !ok {
+				report(e.req, errNoRequest)
+			}
+			continue
+		}
+		req, err := validateRequest(l.T[e.req].Raw)
+		if err != nil {
+			report(e.req, err)
+		}
+		if e.res == -1 {
+			if j := interleaved(l, e.req); j != -1 {
+				orphans[j] = struct{}{}
+				report(e.req, errInterleaved)
+			} else {
+				report(e.req, errNoResponse)
+			}
+			continue
+		}
+		if req != nil {
+			if err = validateResponse(l.T[e.res].Raw, req); err != nil {
+				report(e.res, err)
+			}
+		}
+	}
+	return issues
+}
+
+// interleaved gives an index of the response for the i-th request, which was
+// recorded but not directly after the request, or -1 if there's none.
+func interleaved(l *Log, i int) int {
+	if l.T[i].Stream != 0 {
+		return -1
+	}
+	for j := i + 1; j < len(l.T); j++ {
+		if addrequal(l.T[j].Src, l.T[i].Src) {
+			return -1
+		}
+		if addrequal(l.T[j].Src, l.T[i].Dst) && addrequal(l.T[j].Dst, l.T[i].Src) {
+			return j
+		}
+	}
+	return -1
+}
+
+func validateRequest(raw []byte) (*http.Request, error) {
+	header, body := SplitHeaderBody(raw)
+	if header == nil {
+		return nil, errHeaderEnd
+	}
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(header)))
+	if err != nil {
+		return nil, fmt.Errorf("fakerpc: unable to parse request: %v", err)
+	}
+	if req.Header.Get("Content-Length") != "" {
+		switch n := int64(len(body)); {
+		case n < req.ContentLength:
+			return req, errShortBody
+		case n > req.ContentLength:
+			return req, errExcessBody
+		}
+	}
+	return req, nil
+}
+
+func validateResponse(raw []byte, req *http.Request) error {
+	header, body := SplitHeaderBody(raw)
+	if header == nil {
+		return errHeaderEnd
+	}
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(header)), req)
+	if err != nil {
+		return fmt.Errorf("fakerpc: unable to parse response: %v", err)
+	}
+	res.Body.Close()
+	if req.Method == "HEAD" || res.StatusCode/100 == 1 || res.StatusCode == 204 || res.StatusCode == 304 ||
+		res.Header.Get("Content-Length") == "" {
+		return nil
+	}
+	switch n := int64(len(body)); {
+	case n < res.ContentLength:
+		return errShortBody
+	case n > res.ContentLength:
+		return errExcessBody
+	}
+	return nil
+}
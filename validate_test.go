@@ -0,0 +1,64 @@
+package fakerpc
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	bad := &Log{T: []Transmission{{
+		Src: &cli[0], Dst: srv,
+		Raw: []byte("POST /1 HTTP/1.1\r\nContent-Length: 10\r\n\r\nHAI"),
+	}, {
+		Src: &cli[1], Dst: srv,
+		Raw: []byte("GET /2 HTTP/1.1\r\n\r\n"),
+	}, {
+		Src: srv, Dst: &cli[0],
+		Raw: []byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nOK"),
+	}, {
+		Src: srv, Dst: &cli[1],
+		Raw: []byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nOK"),
+	}, {
+		Src: &cli[2], Dst: srv,
+		Raw: []byte("GET /3 HTTP/1.1\r\n\r\n"),
+	}, {
+		Src: srv, Dst: &cli[2],
+		Raw: []byte("HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nOK"),
+	}, {
+		Src: &cli[2], Dst: srv,
+		Raw: []byte("NOT HTTP\r\n\r\n"),
+	}}}
+	cases := [...]struct {
+		l   *Log
+		exp []Issue
+	}{{
+		log, []Issue{
+			{Index: 6, Addr: &cli[2], Err: errExcessBody},
+			{Index: 8, Addr: &cli[2], Err: errExcessBody},
+		},
+	}, {
+		bad, []Issue{
+			{Index: 0, Addr: &cli[0], Err: errShortBody},
+			{Index: 0, Addr: &cli[0], Err: errInterleaved},
+			{Index: 1, Addr: &cli[1], Err: errInterleaved},
+			{Index: 5, Addr: srv, Err: errShortBody},
+			{Index: 6, Addr: &cli[2]},
+			{Index: 6, Addr: &cli[2], Err: errNoResponse},
+		},
+	}, {
+		NewLog(), []Issue{{Index: -1, Err: errEmptyLog}},
+	}, {
+		&Log{T: []Transmission{{Src: &cli[0]}}}, []Issue{{Index: 0, Addr: &cli[0], Err: errMissingAddr}},
+	}}
+	for i, cas := range cases {
+		issues := Validate(cas.l)
+		if len(issues) != len(cas.exp) {
+			t.Errorf("expected len(issues)=%d; got %d: %v (i=%d)", len(cas.exp), len(issues), issues, i)
+			continue
+		}
+		for j, exp := range cas.exp {
+			is := issues[j]
+			if is.Index != exp.Index || (exp.Addr != nil && !addrequal(is.Addr, exp.Addr)) ||
+				(exp.Err != nil && is.Err != exp.Err) || is.Err == nil {
+				t.Errorf("expected issues[%d]=%v; got %v (i=%d)", j, exp, is, i)
+			}
+		}
+	}
+}
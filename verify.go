@@ -0,0 +1,106 @@
+package fakerpc
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// A Verifier replays requests recorded in a log against a live service and
+// compares its responses with the recorded ones.
+type Verifier struct {
+	// Differ holds rules the responses are compared with.
+	Differ
+	// Client, when non-nil, is used to issue the requests. By default
+	// responses are not decompressed by the client, so their encoding can be
+	// compared as well.
+	Client *http.Client
+}
+
+var verifyClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:              http.ProxyFromEnvironment,
+		DisableCompression: true,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// Verify replays requests recorded in the l against the target URL with
+// a zero Verifier.
+func Verify(l *Log, target string) ([]Change, error) {
+	return (&Verifier{}).Verify(l, target)
+}
+
+// Verify replays every request recorded in the l against the target URL, in
+// order, and gives calls, which responses differ from the recorded ones.
+// A request, which can't be issued, is reported as a modified call too.
+// Tunnelled connections are not replayed.
+func (v *Verifier) Verify(l *Log, target string) ([]Change, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("fakerpc: invalid target URL %q", target)
+	}
+	c, err := calls(l)
+	if err != nil {
+		return nil, err
+	}
+	client := v.Client
+	if client == nil {
+		client = verifyClient
+	}
+	var changes []Change
+	for i := range c {
+		if c[i].Tunnel != nil || c[i].Req.Method == "CONNECT" {
+			continue
+		}
+		live := Connection{Req: c[i].Req, ReqBody: c[i].ReqBody}
+		if live.Res, err = v.replay(client, u, &c[i]); err != nil {
+			changes = append(changes, newChange(Modified, &c[i], []string{"error: " + err.Error()}))
+			continue
+		}
+		if diffs := v.diffCall(&c[i], &live); len(diffs) != 0 {
+			changes = append(changes, newChange(Modified, &c[i], diffs))
+		}
+	}
+	return changes, nil
+}
+
+// replay issues the c's request against the target, giving a raw response.
+func (v *Verifier) replay(client *http.Client, target *url.URL, c *Connection) ([]byte, error) {
+	u := *target
+	u.Path = strings.TrimSuffix(u.Path, "/") + c.Req.URL.Path
+	u.RawPath, u.RawQuery = "", c.Req.URL.RawQuery
+	req, err := http.NewRequest(c.Req.Method, u.String(), bytes.NewReader(c.ReqBody))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.Req.Header {
+		switch k {
+		case "Host", "Content-Length", "Connection", "Transfer-Encoding":
+		default:
+			req.Header[k] = v
+		}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	// The body is dumped with its transfer coding removed, so it's delimited
+	// by the Content-Length header instead.
+	res.Body, res.ContentLength, res.TransferEncoding = ioutil.NopCloser(bytes.NewReader(body)), int64(len(body)), nil
+	return httputil.DumpResponse(res, true)
+}
@@ -0,0 +1,55 @@
+package fakerpc
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var n int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user", func(w http.ResponseWriter, req *http.Request) {
+		n++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":%d,"name":%q}`, n, req.URL.Query().Get("name"))
+	})
+	go http.Serve(l, mux)
+	x := calllog(
+		"GET /user?name=a HTTP/1.1\r\nHost: api.local\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: 22\r\n\r\n"+
+			`{"id": 7, "name": "a"}`,
+		"GET /user?name=b HTTP/1.1\r\nHost: api.local\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: 22\r\n\r\n"+
+			`{"id": 8, "name": "c"}`,
+		"GET /missing HTTP/1.1\r\nHost: api.local\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n",
+	)
+	exp := []Change{
+		{Kind: Modified, Method: "GET", URI: "/user?name=b", Diffs: []string{`body.name: "c" -> "b"`}},
+		{Kind: Modified, Method: "GET", URI: "/missing", Diffs: []string{
+			"status: 200 OK -> 404 Not Found",
+			`header Content-Type: (none) -> "text/plain; charset=utf-8"`,
+			`header X-Content-Type-Options: (none) -> "nosniff"`,
+			`body: "" -> "404 page not found\n"`,
+		}},
+	}
+	v := &Verifier{Differ: Differ{Ignore: []string{"Date"}, IgnoreFields: []string{"id"}}}
+	changes, err := v.Verify(x, "http://"+l.Addr().String())
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if !reflect.DeepEqual(changes, exp) {
+		t.Errorf("expected changes=%v; got %v", exp, changes)
+	}
+	if _, err = Verify(x, "api.local"); err == nil {
+		t.Error("expected err!=nil for a target without a scheme")
+	}
+}
@@ -0,0 +1,261 @@
+package fakerpc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var errFrame = errors.New("fakerpc: ill-formed WebSocket frame")
+
+// WebSocket opcodes.
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+const wsguid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxFrame is a size of the largest WebSocket frame, which is read or recorded.
+const maxFrame = 1 << 30
+
+// A Frame represents a single WebSocket frame.
+type Frame struct {
+	Fin     bool      // whether the frame is the final fragment of a message
+	Opcode  byte      // type of the frame
+	Payload []byte    // unmasked payload
+	Client  bool      // whether the frame was sent by the client
+	Time    time.Time // time the frame was recorded at, if known
+	raw     []byte
+}
+
+// IsWebSocket reports whether the req is a WebSocket upgrade request.
+func IsWebSocket(req *http.Request) bool {
+	return strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
+
+// Frames gives WebSocket frames decoded from the c's tunnel.
+func (c *Connection) Frames() ([]Frame, error) {
+	var frames []Frame
+	for _, t := range c.Tunnel {
+		for p := t.Raw; len(p) != 0; {
+			n, err := frameLen(p)
+			if err != nil || n == 0 || n > len(p) {
+				return nil, errFrame
+			}
+			f, err := parseFrame(p[:n])
+			if err != nil {
+				return nil, err
+			}
+			f.Time = t.Time
+			frames, p = append(frames, *f), p[n:]
+		}
+	}
+	return frames, nil
+}
+
+// frameLen gives a length of the frame which begins the p or 0 if the p is too
+// short to tell. It fails with errFrame if the frame is larger than maxFrame.
+func frameLen(p []byte) (int, error) {
+	if len(p) < 2 {
+		return 0, nil
+	}
+	n, k := uint64(p[1]&0x7f), 2
+	switch n {
+	case 126:
+		if len(p) < 4 {
+			return 0, nil
+		}
+		n, k = uint64(binary.BigEndian.Uint16(p[2:])), 4
+	case 127:
+		if len(p) < 10 {
+			return 0, nil
+		}
+		n, k = binary.BigEndian.Uint64(p[2:]), 10
+	}
+	if n > maxFrame {
+		return 0, errFrame
+	}
+	if p[1]&0x80 != 0 {
+		k += 4
+	}
+	return k + int(n), nil
+}
+
+func parseFrame(p []byte) (*Frame, error) {
+	n, err := frameLen(p)
+	if err != nil || n == 0 || n != len(p) {
+		return nil, errFrame
+	}
+	f := &Frame{
+		Fin:    p[0]&0x80 != 0,
+		Opcode: p[0] & 0x0f,
+		Client: p[1]&0x80 != 0,
+		raw:    p,
+	}
+	k := 2
+	switch p[1] & 0x7f {
+	case 126:
+		k = 4
+	case 127:
+		k = 10
+	}
+	if f.Client {
+		mask := p[k : k+4]
+		k += 4
+		f.Payload = make([]byte, len(p)-k)
+		for i := range f.Payload {
+			f.Payload[i] = p[k+i] ^ mask[i%4]
+		}
+	} else {
+		f.Payload = append([]byte(nil), p[k:]...)
+	}
+	return f, nil
+}
+
+func readFrame(r *bufio.Reader) (*Frame, error) {
+	p, err := r.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	k := 2
+	switch p[1] & 0x7f {
+	case 126:
+		k = 4
+	case 127:
+		k = 10
+	}
+	if p, err = r.Peek(k); err != nil {
+		return nil, errFrame
+	}
+	n, err := frameLen(p)
+	if err != nil || n == 0 {
+		return nil, errFrame
+	}
+	p = make([]byte, n)
+	if _, err = io.ReadFull(r, p); err != nil {
+		return nil, errFrame
+	}
+	return parseFrame(p)
+}
+
+// wsaccept computes a value of the Sec-WebSocket-Accept header for the key.
+func wsaccept(key string) string {
+	h := sha1.Sum([]byte(key + wsguid))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// wshandshake gives the recorded 101 Switching Protocols response with
+// the Sec-WebSocket-Accept header computed for the req.
+func wshandshake(res []byte, req *http.Request) []byte {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(res)), req)
+	if err != nil {
+		return res
+	}
+	resp.Header.Set("Sec-WebSocket-Accept", wsaccept(req.Header.Get("Sec-WebSocket-Key")))
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "HTTP/1.1 %s\r\n", resp.Status)
+	resp.Header.Write(&buf)
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+// serveWebSocket replies to WebSocket frames read from r with the recorded
+// ones. Server frames recorded before the first client frame are sent right
+// away; every client frame, which matches next recorded one by the opcode
+// and the payload, is followed by server frames recorded after it. Client
+// frames matching none are reported with errNoResponse.
+func (srv *Server) serveWebSocket(w io.Writer, r *bufio.Reader, frames []Frame, rem net.Addr) error {
+	k := 0
+	send := func() error {
+		for ; k < len(frames) && !frames[k].Client; k++ {
+			n, err := w.Write(frames[k].raw)
+			srv.Reply(srv.src, rem, int64(n), err)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := send(); err != nil {
+		return err
+	}
+	for {
+		f, err := readFrame(r)
+		if err != nil {
+			return err
+		}
+		j := k
+		for ; j < len(frames); j++ {
+			if frames[j].Client && frames[j].Opcode == f.Opcode && bytes.Equal(frames[j].Payload, f.Payload) {
+				break
+			}
+		}
+		if j == len(frames) {
+			srv.Reply(rem, srv.src, int64(len(f.Payload)), errNoResponse)
+		} else {
+			srv.Reply(rem, srv.src, int64(len(f.Payload)), nil)
+			k = j + 1
+			if err = send(); err != nil {
+				return err
+			}
+		}
+		if f.Opcode == OpClose {
+			return nil
+		}
+	}
+}
+
+// A wsrecorder splits transmissions of an upgraded connection into frames.
+type wsrecorder struct {
+	buf  [2][]byte
+	time [2]time.Time
+	bad  [2]bool // whether an ill-formed frame was sent in the direction
+}
+
+// record appends the p to the buffer of the given direction, returning
+// complete frames along with the time their first byte was recorded at.
+// Once an ill-formed frame is found, the data sent in its direction is no
+// longer split into frames, but it's returned as it's written.
+func (wr *wsrecorder) record(p []byte, dir int) (frames [][]byte, times []time.Time) {
+	now := time.Now()
+	if len(wr.buf[dir]) == 0 {
+		wr.time[dir] = now
+	}
+	wr.buf[dir] = append(wr.buf[dir], p...)
+	for {
+		n, err := frameLen(wr.buf[dir])
+		if err != nil || wr.bad[dir] {
+			wr.bad[dir] = true
+			n = len(wr.buf[dir])
+		}
+		if n == 0 || n > len(wr.buf[dir]) {
+			break
+		}
+		frames = append(frames, wr.buf[dir][:n:n])
+		times = append(times, wr.time[dir])
+		wr.buf[dir], wr.time[dir] = wr.buf[dir][n:], now
+	}
+	return frames, times
+}
+
+// isWebSocketUpgrade reports whether p begins with a response accepting
+// WebSocket upgrade.
+func isWebSocketUpgrade(p []byte) bool {
+	header, _ := SplitHeaderBody(p)
+	return bytes.HasPrefix(header, []byte("HTTP/1.1 101")) &&
+		bytes.Contains(bytes.ToLower(header), []byte("upgrade: websocket"))
+}
@@ -0,0 +1,276 @@
+package fakerpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func wsframe(op byte, payload string, masked bool) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(0x80 | op)
+	var mask byte
+	if masked {
+		mask = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		buf.WriteByte(mask | byte(n))
+	default:
+		buf.WriteByte(mask | 126)
+		binary.Write(&buf, binary.BigEndian, uint16(n))
+	}
+	p := []byte(payload)
+	if masked {
+		key := []byte{0x12, 0x34, 0x56, 0x78}
+		buf.Write(key)
+		for i := range p {
+			p[i] ^= key[i%4]
+		}
+	}
+	buf.Write(p)
+	return buf.Bytes()
+}
+
+// wssrv serves a WebSocket service, which greets a client and replies with
+// every text message upper-cased.
+func wssrv(t *testing.T) string {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !IsWebSocket(req) {
+			http.Error(w, "not a websocket", http.StatusBadRequest)
+			return
+		}
+		c, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n" +
+			"Connection: Upgrade\r\nSec-WebSocket-Accept: " +
+			wsaccept(req.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+		rw.Write(wsframe(OpText, "hello", false))
+		rw.Flush()
+		for {
+			f, err := readFrame(rw.Reader)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if f.Opcode == OpClose {
+				rw.Write(wsframe(OpClose, "", false))
+				rw.Flush()
+				return
+			}
+			rw.Write(wsframe(OpText, strings.ToUpper(string(f.Payload)), false))
+			rw.Flush()
+		}
+	})
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(l, h)
+	return l.Addr().String()
+}
+
+func wsexpect(t *testing.T, r *bufio.Reader, op byte, payload string) {
+	f, err := readFrame(r)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if f.Opcode != op || string(f.Payload) != payload {
+		t.Errorf("expected frame %d %q; got %d %q", op, payload, f.Opcode, f.Payload)
+	}
+}
+
+func wsclient(t *testing.T, addr, key string, msg ...string) {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	defer c.Close()
+	req, err := http.NewRequest("GET", "http://"+addr+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err = req.Write(c); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	r := bufio.NewReader(c)
+	res, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected res.StatusCode=101; got %d", res.StatusCode)
+	}
+	if s := res.Header.Get("Sec-WebSocket-Accept"); s != wsaccept(key) {
+		t.Errorf("expected Sec-WebSocket-Accept=%q; got %q", wsaccept(key), s)
+	}
+	wsexpect(t, r, OpText, "hello")
+	for _, msg := range msg {
+		c.Write(wsframe(OpText, msg, true))
+		wsexpect(t, r, OpText, strings.ToUpper(msg))
+	}
+	c.Write(wsframe(OpClose, "", true))
+	wsexpect(t, r, OpClose, "")
+}
+
+func TestWebSocket(t *testing.T) {
+	p, err := NewProxy("localhost:0", "http://"+wssrv(t))
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	go p.ListenAndServe()
+	msg := []string{"abc", strings.Repeat("x", 300), "def"}
+	wsclient(t, p.Addr().String(), "dGhlIHNhbXBsZSBub25jZQ==", msg...)
+	log, err := p.Stop()
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	conn, err := NewConnections(log)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if len(conn) != 1 || len(conn[0]) != 1 {
+		t.Fatalf("expected single connection with 1 request; got %v", conn)
+	}
+	frames, err := conn[0][0].Frames()
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if n := 2*len(msg) + 3; len(frames) != n {
+		t.Fatalf("expected len(frames)=%d; got %d", n, len(frames))
+	}
+	for i, f := range frames {
+		if f.Time.IsZero() {
+			t.Errorf("expected frames[%d].Time to be non-zero", i)
+		}
+	}
+	if frames[1].Client != true || string(frames[1].Payload) != msg[0] {
+		t.Errorf("expected frames[1] to be client's %q; got %+v", msg[0], frames[1])
+	}
+	srv, err := NewServer("localhost:0", log)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- srv.ListenAndServe()
+	}()
+	wsclient(t, srv.Addr().String(), "x3JJHMbDL1EzLkh9GBhXDw==", msg...)
+	if err = <-done; err != nil {
+		t.Errorf("expected err=nil; got %q", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	wsclient(t, ts.Listener.Addr().String(), "AQIDBAUGBwgJCgsMDQ4PEA==", msg...)
+}
+
+func TestFrameOversized(t *testing.T) {
+	huge := []byte{0x82, 127, 0x80, 0, 0, 0, 0, 0, 0, 0x01, 'x'}
+	if _, err := frameLen(huge); err != errFrame {
+		t.Errorf("expected err=errFrame; got %v", err)
+	}
+	if _, err := readFrame(bufio.NewReader(bytes.NewReader(huge))); err != errFrame {
+		t.Errorf("expected err=errFrame; got %v", err)
+	}
+	c := &Connection{Tunnel: []Transmission{{Raw: huge}}}
+	if _, err := c.Frames(); err != errFrame {
+		t.Errorf("expected err=errFrame; got %v", err)
+	}
+	var wr wsrecorder
+	if frames, _ := wr.record(huge, 0); len(frames) != 1 || !bytes.Equal(frames[0], huge) {
+		t.Errorf("expected the oversized frame to be recorded as is; got %q", frames)
+	}
+	if frames, _ := wr.record(wsframe(OpText, "hi", false), 0); len(frames) != 1 {
+		t.Errorf("expected the data following an oversized frame to be recorded as is; got %q", frames)
+	}
+}
+
+func TestRecConnUpgrade(t *testing.T) {
+	cases := [...]struct {
+		writes []string
+		done   bool
+		ws     bool
+	}{
+		{[]string{"HTTP/1.1 200 OK\r\n", "Content-Length: 0\r\n\r\n"}, true, false},
+		{[]string{"HTTP/1", ".0 101"}, true, false},
+		{[]string{"HTTP/1", ".1 101 Switching Protocols\r\n"}, false, false},
+		{[]string{"HTTP/1.1 101 Switching Protocols\r\n", "Upgrade: h2c\r\n\r\n"}, true, false},
+		{[]string{"HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n", "\r\n"}, true, true},
+	}
+	for i, cas := range cases {
+		rc := &recConn{
+			t:   []Transmission{{Src: &cli[0], Dst: srv, Raw: []byte("GET / HTTP/1.1\r\n\r\n")}},
+			rec: noopRecord,
+			src: srv,
+			dst: &cli[0],
+		}
+		for _, w := range cas.writes {
+			rc.record([]byte(w), rc.src, rc.dst)
+		}
+		if rc.done != cas.done || (rc.ws != nil) != cas.ws {
+			t.Errorf("expected done=%v, ws=%v; got %v, %v (i=%d)", cas.done, cas.ws, rc.done, rc.ws != nil, i)
+		}
+	}
+	// Frames sent in a single write with the response are split off of it.
+	res := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n"
+	hello := wsframe(OpText, "hello", false)
+	rc := &recConn{
+		t:   []Transmission{{Src: &cli[0], Dst: srv, Raw: []byte("GET / HTTP/1.1\r\n\r\n")}},
+		rec: noopRecord,
+		src: srv,
+		dst: &cli[0],
+	}
+	rc.record(append([]byte(res), hello...), rc.src, rc.dst)
+	if len(rc.t) != 3 || string(rc.t[1].Raw) != res || !bytes.Equal(rc.t[2].Raw, hello) {
+		t.Errorf("expected the response and the frame to be recorded separately; got %v", rc.t)
+	}
+}
+
+func TestServeWebSocketMiss(t *testing.T) {
+	var frames []Frame
+	for _, p := range [][]byte{
+		wsframe(OpText, "hello", false),
+		wsframe(OpText, "abc", true),
+		wsframe(OpText, "ABC", false),
+		wsframe(OpClose, "", true),
+	} {
+		f, err := parseFrame(p)
+		if err != nil {
+			t.Fatalf("expected err=nil; got %q", err)
+		}
+		frames = append(frames, *f)
+	}
+	var misses []string
+	srv := &Server{Reply: func(_, _ net.Addr, _ int64, err error) {
+		if err != nil {
+			misses = append(misses, err.Error())
+		}
+	}}
+	var in bytes.Buffer
+	in.Write(wsframe(OpText, "xyz", true))
+	in.Write(wsframe(OpText, "abc", true))
+	in.Write(wsframe(OpClose, "", true))
+	var out bytes.Buffer
+	if err := srv.serveWebSocket(&out, bufio.NewReader(&in), frames, nil); err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if len(misses) != 1 || misses[0] != errNoResponse.Error() {
+		t.Errorf("expected single miss; got %q", misses)
+	}
+	if exp := append(wsframe(OpText, "hello", false), wsframe(OpText, "ABC", false)...); !bytes.Equal(out.Bytes(), exp) {
+		t.Errorf("expected out=%q; got %q", exp, out.Bytes())
+	}
+}
@@ -0,0 +1,271 @@
+package fakerpc
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errNotXMLCall = errors.New("fakerpc: payload is neither XML-RPC nor SOAP message")
+
+// A XMLCall represents XML-RPC or SOAP message, either a call or a response.
+type XMLCall struct {
+	SOAP   bool     // whether the message is a SOAP envelope
+	Method string   // name of the called method; empty for responses
+	Params []string // canonicalized parameters or results
+	Fault  bool     // whether the message is a fault response
+}
+
+// ParseXMLCall parses XML-RPC methodCall/methodResponse or SOAP envelope from
+// the given payload. Parameters are canonicalized, so insignificant whitespace
+// and order of attributes do not matter when comparing them.
+func ParseXMLCall(p []byte) (*XMLCall, error) {
+	root, err := parseXML(p)
+	if err != nil {
+		return nil, err
+	}
+	switch root.name.Local {
+	case "methodCall":
+		x := &XMLCall{}
+		if name := root.find("methodName"); name != nil {
+			x.Method = name.text
+		}
+		if x.Method == "" {
+			return nil, errNotXMLCall
+		}
+		x.Params = xmlrpcParams(root)
+		return x, nil
+	case "methodResponse":
+		x := &XMLCall{}
+		if fault := root.find("fault"); fault != nil {
+			x.Fault = true
+			if v := fault.find("value"); v != nil {
+				x.Params = []string{xmlrpcValue(v)}
+			}
+			return x, nil
+		}
+		x.Params = xmlrpcParams(root)
+		return x, nil
+	case "Envelope":
+		body := root.find("Body")
+		if body == nil || len(body.child) == 0 {
+			return nil, errNotXMLCall
+		}
+		x, op := &XMLCall{SOAP: true}, body.child[0]
+		if op.name.Local == "Fault" {
+			x.Fault = true
+		} else if !strings.HasSuffix(op.name.Local, "Response") {
+			x.Method = op.name.Local
+		}
+		for _, param := range op.child {
+			x.Params = append(x.Params, param.canonical())
+		}
+		return x, nil
+	}
+	return nil, errNotXMLCall
+}
+
+// Equal reports whether x and y represent the same method called with the same
+// parameters.
+func (x *XMLCall) Equal(y *XMLCall) bool {
+	if x.SOAP != y.SOAP || x.Method != y.Method || x.Fault != y.Fault || len(x.Params) != len(y.Params) {
+		return false
+	}
+	for i := range x.Params {
+		if x.Params[i] != y.Params[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// String gives human-readable representation of the message.
+func (x *XMLCall) String() string {
+	params := strings.Join(x.Params, ", ")
+	switch {
+	case x.Fault:
+		return "fault(" + params + ")"
+	case x.Method == "":
+		return "=> (" + params + ")"
+	}
+	return x.Method + "(" + params + ")"
+}
+
+// MatchXML is a Matcher, which matches XML-RPC and SOAP requests by a method
+// name and canonicalized parameters. Requests, which payload is not a XML
+// message, are matched by a raw body.
+func MatchXML(req *http.Request, body []byte, c *Connection) bool {
+	if req.Method != c.Req.Method || req.URL.Path != c.Req.URL.Path {
+		return false
+	}
+	x, err := ParseXMLCall(body)
+	if err != nil {
+		return bytes.Equal(body, c.ReqBody)
+	}
+	y, err := ParseXMLCall(c.ReqBody)
+	if err != nil {
+		return false
+	}
+	return x.Equal(y)
+}
+
+func xmlrpcParams(root *xmlnode) (params []string) {
+	if p := root.find("params"); p != nil {
+		for _, param := range p.child {
+			if v := param.find("value"); v != nil {
+				params = append(params, xmlrpcValue(v))
+			}
+		}
+	}
+	return
+}
+
+// xmlrpcValue gives a literal representation of the XML-RPC value.
+func xmlrpcValue(v *xmlnode) string {
+	if len(v.child) == 0 {
+		return strconv.Quote(v.text)
+	}
+	typ := v.child[0]
+	switch typ.name.Local {
+	case "string":
+		return strconv.Quote(typ.text)
+	case "boolean":
+		if typ.text == "1" {
+			return "true"
+		}
+		return "false"
+	case "nil":
+		return "nil"
+	case "base64":
+		return "base64:" + typ.text
+	case "array":
+		var s []string
+		if data := typ.find("data"); data != nil {
+			for _, v := range data.child {
+				s = append(s, xmlrpcValue(v))
+			}
+		}
+		return "[" + strings.Join(s, ", ") + "]"
+	case "struct":
+		var s []string
+		for _, member := range typ.child {
+			name, v := member.find("name"), member.find("value")
+			if name != nil && v != nil {
+				s = append(s, name.text+": "+xmlrpcValue(v))
+			}
+		}
+		sort.Strings(s)
+		return "{" + strings.Join(s, ", ") + "}"
+	}
+	return typ.text
+}
+
+// A xmlnode represents a single XML element with whitespace-trimmed text.
+type xmlnode struct {
+	name  xml.Name
+	attr  []xml.Attr
+	text  string
+	child []*xmlnode
+}
+
+func parseXML(p []byte) (*xmlnode, error) {
+	var (
+		root  *xmlnode
+		stack []*xmlnode
+		dec   = xml.NewDecoder(bytes.NewReader(p))
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			n := &xmlnode{name: tok.Name}
+			for _, attr := range tok.Attr {
+				if attr.Name.Space != "xmlns" && attr.Name.Local != "xmlns" {
+					n.attr = append(n.attr, attr)
+				}
+			}
+			sort.Sort(xmlattrs(n.attr))
+			if len(stack) == 0 {
+				if root != nil {
+					return nil, errors.New("fakerpc: multiple XML root elements")
+				}
+				root = n
+			} else {
+				parent := stack[len(stack)-1]
+				parent.child = append(parent.child, n)
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) != 0 {
+				if s := strings.TrimSpace(string(tok)); s != "" {
+					stack[len(stack)-1].text += s
+				}
+			}
+		}
+	}
+	if root == nil {
+		return nil, errNotXMLCall
+	}
+	return root, nil
+}
+
+// find gives first child element with the given local name.
+func (n *xmlnode) find(local string) *xmlnode {
+	for _, c := range n.child {
+		if c.name.Local == local {
+			return c
+		}
+	}
+	return nil
+}
+
+// canonical gives XML representation of the n with sorted attributes, no
+// namespace declarations and no insignificant whitespace.
+func (n *xmlnode) canonical() string {
+	var buf bytes.Buffer
+	n.write(&buf)
+	return buf.String()
+}
+
+func (n *xmlnode) write(buf *bytes.Buffer) {
+	buf.WriteString("<" + n.name.Local)
+	for _, attr := range n.attr {
+		buf.WriteString(" " + attr.Name.Local + "=\"")
+		xml.EscapeText(buf, []byte(attr.Value))
+		buf.WriteString("\"")
+	}
+	if n.text == "" && len(n.child) == 0 {
+		buf.WriteString("/>")
+		return
+	}
+	buf.WriteString(">")
+	xml.EscapeText(buf, []byte(n.text))
+	for _, c := range n.child {
+		c.write(buf)
+	}
+	buf.WriteString("</" + n.name.Local + ">")
+}
+
+type xmlattrs []xml.Attr
+
+func (a xmlattrs) Len() int      { return len(a) }
+func (a xmlattrs) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a xmlattrs) Less(i, j int) bool {
+	if a[i].Name.Local != a[j].Name.Local {
+		return a[i].Name.Local < a[j].Name.Local
+	}
+	return a[i].Name.Space < a[j].Name.Space
+}
@@ -0,0 +1,138 @@
+package fakerpc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const xmlrpcCall = `<?xml version="1.0"?>
+<methodCall>
+  <methodName>examples.getStateName</methodName>
+  <params>
+    <param><value><i4>41</i4></value></param>
+    <param><value>Ohio</value></param>
+    <param><value><struct>
+      <member><name>b</name><value><boolean>1</boolean></value></member>
+      <member><name>a</name><value><array><data>
+        <value><string>x</string></value><value><double>1.5</double></value>
+      </data></array></value></member>
+    </struct></value></param>
+  </params>
+</methodCall>`
+
+const xmlrpcFault = `<?xml version="1.0"?>
+<methodResponse><fault><value><struct>
+  <member><name>faultCode</name><value><int>4</int></value></member>
+  <member><name>faultString</name><value><string>Too many parameters.</string></value></member>
+</struct></value></fault></methodResponse>`
+
+const soapCall = `<?xml version="1.0"?>
+<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope" xmlns:m="http://www.example.org/stock">
+  <soap:Body>
+    <m:GetStockPrice>
+      <m:StockName currency="USD" exchange="NYSE">IBM</m:StockName>
+    </m:GetStockPrice>
+  </soap:Body>
+</soap:Envelope>`
+
+const soapCallCompact = `<Envelope xmlns="http://www.w3.org/2003/05/soap-envelope"><Body>` +
+	`<GetStockPrice xmlns="http://www.example.org/stock"><StockName exchange="NYSE" ` +
+	`currency="USD">IBM</StockName></GetStockPrice></Body></Envelope>`
+
+func TestParseXMLCall(t *testing.T) {
+	cases := [...]struct {
+		p string
+		x XMLCall
+	}{{
+		xmlrpcCall,
+		XMLCall{Method: "examples.getStateName", Params: []string{
+			"41", `"Ohio"`, `{a: ["x", 1.5], b: true}`,
+		}},
+	}, {
+		xmlrpcFault,
+		XMLCall{Fault: true, Params: []string{`{faultCode: 4, faultString: "Too many parameters."}`}},
+	}, {
+		soapCall,
+		XMLCall{SOAP: true, Method: "GetStockPrice", Params: []string{
+			`<StockName currency="USD" exchange="NYSE">IBM</StockName>`,
+		}},
+	}}
+	for i, cas := range cases {
+		x, err := ParseXMLCall([]byte(cas.p))
+		if err != nil {
+			t.Errorf("expected err=nil; got %q (i=%d)", err, i)
+			continue
+		}
+		if !reflect.DeepEqual(*x, cas.x) {
+			t.Errorf("expected x=%+v; got %+v (i=%d)", cas.x, *x, i)
+		}
+	}
+	x, err := ParseXMLCall([]byte(soapCallCompact))
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	if !x.Equal(&cases[2].x) {
+		t.Errorf("expected %v to be equal to %v", x, &cases[2].x)
+	}
+	for i, p := range []string{"", "{}", "<html/>", "<methodCall></methodCall>"} {
+		if _, err := ParseXMLCall([]byte(p)); err == nil {
+			t.Errorf("expected err!=nil (i=%d)", i)
+		}
+	}
+}
+
+func xmlreq(body string) []byte {
+	return []byte(fmt.Sprintf("POST /RPC2 HTTP/1.1\r\nHost: localhost\r\n"+
+		"Content-Type: text/xml\r\nContent-Length: %d\r\n\r\n%s", len(body), body))
+}
+
+func xmlres(body string) []byte {
+	return []byte(fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/xml\r\n"+
+		"Content-Length: %d\r\n\r\n%s", len(body), body))
+}
+
+func TestMatchXML(t *testing.T) {
+	log := &Log{T: []Transmission{
+		{Src: &cli[0], Dst: srv, Raw: xmlreq(xmlrpcCall)},
+		{Src: srv, Dst: &cli[0], Raw: xmlres("first")},
+		{Src: &cli[0], Dst: srv, Raw: xmlreq(soapCall)},
+		{Src: srv, Dst: &cli[0], Raw: xmlres("second")},
+	}}
+	s, err := NewServer("localhost:0", log)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %q", err)
+	}
+	s.Match = MatchXML
+	done := make(chan error)
+	go func() {
+		done <- s.ListenAndServe()
+	}()
+	var (
+		c   = &http.Client{Transport: &http.Transport{}}
+		url = "http://" + s.Addr().String() + "/RPC2"
+	)
+	for i, cas := range [...]struct{ req, res string }{
+		{soapCallCompact, "second"},
+		{strings.Replace(xmlrpcCall, "  ", "", -1), "first"},
+	} {
+		res, err := c.Post(url, "text/xml", strings.NewReader(cas.req))
+		if err != nil {
+			t.Fatalf("expected err=nil; got %q (i=%d)", err, i)
+		}
+		var buf bytes.Buffer
+		io.Copy(&buf, res.Body)
+		res.Body.Close()
+		if buf.String() != cas.res {
+			t.Errorf("expected res=%q; got %q (i=%d)", cas.res, buf.String(), i)
+		}
+	}
+	c.Transport.(*http.Transport).CloseIdleConnections()
+	if err = <-done; err != nil {
+		t.Errorf("expected err=nil; got %q", err)
+	}
+}